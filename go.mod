@@ -3,11 +3,20 @@ module bitbucket.org/centeva/collie
 go 1.16
 
 require (
+	github.com/denisenkom/go-mssqldb v0.11.0
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/jackc/pgconn v1.10.0
 	github.com/jackc/pgx/v4 v4.13.0
-	github.com/machinebox/graphql v0.2.2 // indirect
+	github.com/machinebox/graphql v0.2.2
+	github.com/miekg/dns v1.1.43
+	github.com/minio/minio-go/v7 v7.0.20
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.12.2
+	github.com/robfig/cron/v3 v3.0.1
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	k8s.io/api v0.22.0
 	k8s.io/apimachinery v0.22.0
 	k8s.io/client-go v0.22.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"bitbucket.org/centeva/collie/packages/command"
 	"bitbucket.org/centeva/collie/packages/external"
@@ -11,18 +12,17 @@ func main() {
 	log.SetFlags(0)
 
 	flagProvider := external.NewFlagProvider()
-	bitbucketManager := external.NewBitbucketManager()
-	githubManager := external.NewGithubManager()
+	bitbucketManager := external.NewInstrumentedGitProvider("bitbucket", external.NewBitbucketManager())
+	githubManager := external.NewInstrumentedGitProvider("github", external.NewGithubManager())
 	gitProviderFactory := external.NewGitProviderFactory(bitbucketManager, githubManager)
 	kubernetesManager := &external.KubernetesManager{}
-	postgresManager := external.NewPostgresManager()
+	databaseManager := external.NewDatabaseManager()
 	fileReader := &external.FileReader{}
 
-	cmd := command.NewCommandParser(flagProvider, gitProviderFactory, kubernetesManager, postgresManager, fileReader)
+	cmd := command.NewCommandParser(flagProvider, gitProviderFactory, kubernetesManager, databaseManager, fileReader)
 
-	err := cmd.ParseCommands()
-
-	if err != nil {
-		panic(err)
+	if err := cmd.ParseCommands(); err != nil {
+		log.Printf("%s", err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,82 @@
+package command
+
+import (
+	"context"
+	"io"
+	"log"
+	"strconv"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"github.com/pkg/errors"
+)
+
+// backupFlags returns the backup config of the --Backup flags, nil when --Backup is not set.
+func backupFlags(destination *string, endpoint *string, region *string, insecure *bool, retention *string) (config *external.BackupConfig, err error) {
+	if destination == nil || *destination == "" {
+		return nil, nil
+	}
+
+	config = &external.BackupConfig{Destination: *destination}
+
+	if endpoint != nil {
+		config.Endpoint = *endpoint
+	}
+
+	if region != nil {
+		config.Region = *region
+	}
+
+	if insecure != nil {
+		config.Insecure = *insecure
+	}
+
+	if retention != nil && *retention != "" {
+		if config.Retention, err = strconv.Atoi(*retention); err != nil {
+			return nil, errors.Wrap(err, "Invalid BackupRetention")
+		}
+	}
+
+	if _, err = external.NewBackupStore(*config); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// backupDatabase saves a backup of the database, then removes its backups beyond the retention.
+func backupDatabase(ctx context.Context, databaseManager external.IDatabaseManager, config external.BackupConfig, database string) (err error) {
+	store, err := external.NewBackupStore(config)
+	if err != nil {
+		return err
+	}
+
+	databases, err := databaseManager.ListDatabases(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !Contains(databases, database) {
+		log.Printf("Database %s does not exist, nothing to back up", database)
+		return
+	}
+
+	location, err := store.Save(ctx, database, func(w io.Writer) error {
+		return databaseManager.BackupDatabase(ctx, database, w)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Failed to back up database %s", database)
+	}
+
+	log.Printf("Database %s backed up to %s", database, location)
+
+	removed, err := store.Prune(ctx, database, config.Retention)
+	for _, backup := range removed {
+		log.Printf("Removed backup %s", backup)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "Failed to remove old backups of %s", database)
+	}
+
+	return
+}
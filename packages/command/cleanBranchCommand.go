@@ -3,7 +3,6 @@ package command
 import (
 	"fmt"
 	"log"
-	"os"
 	"reflect"
 	"regexp"
 	"strings"
@@ -25,21 +24,23 @@ func NewCleanBranchCommand(flagProvider external.IFlagProvider) *CleanBranchComm
 	}
 }
 
-func (c *CleanBranchCommand) IsCurrent() bool {
-	return len(os.Args) > 1 && strings.EqualFold(os.Args[1], "CleanBranch")
+func (c *CleanBranchCommand) Names() []string {
+	return []string{"CleanBranch", "clean-branch"}
 }
 
-func (c *CleanBranchCommand) GetFlags() (err error) {
+func (c *CleanBranchCommand) GetFlags(args []string) (err error) {
 	c.Logger = c.cmd.String("Logger", string(CLI), "Log output style to use [cli|teamcity]")
 
-	if len(os.Args) <= 2 || os.Args[2] == "" {
+	if err = c.cmd.Parse(args); err != nil {
+		return err
+	}
+
+	if c.cmd.Arg(0) == "" {
 		c.cmd.PrintDefaults()
 		return errors.New("Missing branch, see usage.")
 	}
 
-	c.CleanBranch = os.Args[2]
-
-	c.cmd.Parse(os.Args[3:])
+	c.CleanBranch = c.cmd.Arg(0)
 
 	if c.CleanBranch == "" {
 		return errors.New("CleanBranch is required")
@@ -1,46 +1,86 @@
 package command
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
 	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
 	"gopkg.in/yaml.v3"
 )
 
 type CleanupCommand struct {
 	gitProviderFactory *external.GitProviderFactory
 	kubernetesManager  external.IKubernetesManager
+	databaseManager    external.IDatabaseManager
 	cmd                external.IFlagSet
 
 	cleanupConfigPath string
+	configRaw         []byte
+	templatePath      string
+	templateRaw       []byte
 	NamespaceLabel    *string
+	Interval          *string
+	HealthAddress     *string
+	PushGateway       *string
+	Context           *string
+	KubeNamespace     *string
 	CleanupConfig     *CleanupConfig
 	fileReader        external.IFileReader
+
+	readyMu sync.RWMutex
+	ready   bool
 }
 
-func NewCleanupCommand(flagProvider external.IFlagProvider, kubernetesManager external.IKubernetesManager, FileReader external.IFileReader, gitProviderFactory *external.GitProviderFactory) *CleanupCommand {
+func NewCleanupCommand(flagProvider external.IFlagProvider, kubernetesManager external.IKubernetesManager, databaseManager external.IDatabaseManager, FileReader external.IFileReader, gitProviderFactory *external.GitProviderFactory) *CleanupCommand {
 	return &CleanupCommand{
 		fileReader:         FileReader,
 		gitProviderFactory: gitProviderFactory,
 		kubernetesManager:  kubernetesManager,
+		databaseManager:    databaseManager,
 		cmd:                flagProvider.NewFlagSet("Cleanup", "Compares open Pull Requests with namespaces in the cluster and cleanup extras, Usage: Cleanup <CleanupConfigPath>"),
 	}
 }
 
-func (c *CleanupCommand) IsCurrent() bool {
-	return len(os.Args) > 1 && strings.EqualFold(os.Args[1], "Cleanup")
+func (c *CleanupCommand) Names() []string {
+	return []string{"Cleanup"}
 }
 
 type CleanupConfig struct {
-	Kubeconfig  string                     `yaml:"kubeconfig"`
-	GitProvider *ConfigGitProvider         `yaml:"gitProvider,omitempty"`
-	JobConfig   *external.CleanupJobConfig `yaml:"job,omitempty"`
+	Kubeconfig    string                     `yaml:"kubeconfig"`
+	Context       string                     `yaml:"context"`
+	KubeNamespace string                     `yaml:"kubeNamespace"`
+	Mode          CleanupMode                `yaml:"mode"`
+	GitProvider   *ConfigGitProvider         `yaml:"gitProvider,omitempty"`
+	JobConfig     *external.CleanupJobConfig `yaml:"job,omitempty"`
+	Webhook       *ConfigWebhook             `yaml:"webhook,omitempty"`
+	Clusters      []ConfigCluster            `yaml:"clusters,omitempty"`
+
+	LeaderElection *external.LeaderElectionConfig `yaml:"leaderElection,omitempty"`
+}
+
+func (c *CleanupConfig) Cluster() external.ClusterConfig {
+	return external.ClusterConfig{
+		Kubeconfig: c.Kubeconfig,
+		Context:    c.Context,
+		Namespace:  c.KubeNamespace,
+	}
+}
+
+type ConfigWebhook struct {
+	Secret string `yaml:"secret"`
 }
 
 type ConfigGitProvider struct {
@@ -62,23 +102,35 @@ type ConfigGithubArgs struct {
 	Username     string `yaml:"username"`
 }
 
-func (c *CleanupCommand) GetFlags() (err error) {
+func (c *CleanupCommand) GetFlags(args []string) (err error) {
 	c.NamespaceLabel = c.cmd.String("NamespaceLabel", "dev.centeva.meta=PullRequest", "Set the label used to check if a namespace can be cleaned up")
+	c.Interval = c.cmd.String("Interval", "", "Keep running and repeat cleanup on a duration (1h) or cron expression (0 2 * * *)")
+	c.HealthAddress = c.cmd.String("HealthAddress", ":8081", "Address for the /healthz, /readyz and /metrics endpoints when running with --Interval")
+	c.PushGateway = c.cmd.String("PushGateway", "", "Prometheus pushgateway url to push metrics to after a single run")
+	c.Context = c.cmd.String("Context", "", "Kubeconfig context to use, overrides context in the cleanupConfig file")
+	c.KubeNamespace = c.cmd.String("KubeNamespace", "", "Namespace used when job.namespace or leaderElection.namespace is not set, overrides kubeNamespace in the cleanupConfig file")
+
+	if err = c.cmd.Parse(args); err != nil {
+		return err
+	}
 
-	if len(os.Args) <= 2 || os.Args[2] == "" {
+	if c.cmd.Arg(0) == "" {
 		c.cmd.PrintDefaults()
 		return errors.New("Cleanup requires a cleanupConfig file, check usage.")
 	}
 
-	c.cleanupConfigPath = os.Args[2]
-	c.cmd.Parse(os.Args[3:])
-
-	c.CleanupConfig, err = readConfigFile(c.fileReader, c.cleanupConfigPath)
+	c.cleanupConfigPath = c.cmd.Arg(0)
 
-	if err != nil {
+	if _, err = c.ReloadConfig(c.cleanupConfigPath); err != nil {
 		return errors.Wrap(err, "Failed to read config")
 	}
 
+	if *c.Interval != "" {
+		if _, err = ParseSchedule(*c.Interval); err != nil {
+			return errors.Wrap(err, "Failed to parse Interval")
+		}
+	}
+
 	return
 }
 
@@ -89,90 +141,420 @@ func readConfigFile(fileReader external.IFileReader, path string) (config *Clean
 		return nil, errors.Wrap(err, "Failed to read config file")
 	}
 
+	if config, err = parseConfig(file); err != nil {
+		return nil, err
+	}
+
+	if _, err = loadJobTemplate(fileReader, config, path); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// validateJobMode checks that a job mode config has the job section and an image for the teardown container, set by
+// job.image or by the container of the job template.
+func validateJobMode(config *CleanupConfig) error {
+	if config.Mode != CleanupModeJob {
+		return nil
+	}
+
+	if config.JobConfig == nil {
+		return errors.New("Job mode requires a job section in the cleanupConfig file")
+	}
+
+	if config.JobConfig.Image != "" {
+		return nil
+	}
+
+	if template := config.JobConfig.Template; template != nil {
+		for _, container := range template.Spec.Template.Spec.Containers {
+			if container.Name == external.TeardownContainerName && container.Image != "" {
+				return nil
+			}
+		}
+	}
+
+	return errors.Errorf("Job mode requires job.image, or a %s container with an image in the job template", external.TeardownContainerName)
+}
+
+func parseConfig(file []byte) (config *CleanupConfig, err error) {
 	if err = yaml.Unmarshal(file, &config); err != nil {
 		return nil, errors.Wrap(err, "Failed to unmarshal config file")
 	}
 
+	if config == nil {
+		return nil, errors.New("Config file is empty")
+	}
+
+	switch config.Mode {
+	case "":
+		config.Mode = CleanupModeJob
+	case CleanupModeJob, CleanupModeInProcess:
+	default:
+		return nil, errors.Errorf("Unknown mode '%s' must be one of [job|inProcess]", config.Mode)
+	}
+
+	if config.JobConfig != nil {
+		if _, err = teardownActionSteps(config.JobConfig.Actions); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = validateClusters(config.Clusters); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// jobTemplatePath is the path of the job template, a relative template path is relative to the config file. It is
+// empty when the config has no job template.
+func jobTemplatePath(config *CleanupConfig, configPath string) string {
+	if config.JobConfig == nil || config.JobConfig.TemplateFile == "" {
+		return ""
+	}
+
+	templatePath := config.JobConfig.TemplateFile
+	if !filepath.IsAbs(templatePath) {
+		templatePath = filepath.Join(filepath.Dir(configPath), templatePath)
+	}
+
+	return templatePath
+}
+
+// loadJobTemplate reads and parses the job template, file is the contents of the template.
+func loadJobTemplate(fileReader external.IFileReader, config *CleanupConfig, configPath string) (file []byte, err error) {
+	templatePath := jobTemplatePath(config, configPath)
+	if templatePath == "" {
+		return
+	}
+
+	if file, err = fileReader.ReadFile(templatePath); err != nil {
+		return nil, errors.Wrap(err, "Failed to read job template")
+	}
+
+	if config.JobConfig.Template, err = external.ParseJobTemplate(file); err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse job template %s", templatePath)
+	}
+
 	return
 }
 
+// ReloadConfig reads the cleanupConfig file and replaces CleanupConfig when the contents of the file or of its job
+// template have changed.
+func (c *CleanupCommand) ReloadConfig(path string) (changed bool, err error) {
+	file, err := c.fileReader.ReadFile(path)
+
+	if err != nil {
+		return false, errors.Wrap(err, "Failed to read config file")
+	}
+
+	if c.CleanupConfig != nil && c.cleanupConfigPath == path && bytes.Equal(file, c.configRaw) && !c.templateChanged() {
+		return false, nil
+	}
+
+	config, err := parseConfig(file)
+
+	if err != nil {
+		return false, err
+	}
+
+	template, err := loadJobTemplate(c.fileReader, config, path)
+	if err != nil {
+		return false, err
+	}
+
+	if err = validateJobMode(config); err != nil {
+		return false, err
+	}
+
+	c.cleanupConfigPath = path
+	c.configRaw = file
+	c.templatePath = jobTemplatePath(config, path)
+	c.templateRaw = template
+	c.CleanupConfig = config
+	return true, nil
+}
+
+// templateChanged reports whether the job template of the current config has changed, a template that can't be read
+// has changed so the reload reports the error.
+func (c *CleanupCommand) templateChanged() bool {
+	if c.templatePath == "" {
+		return false
+	}
+
+	file, err := c.fileReader.ReadFile(c.templatePath)
+	return err != nil || !bytes.Equal(file, c.templateRaw)
+}
+
 func (c *CleanupCommand) Execute() (err error) {
+	if c.Interval == nil || *c.Interval == "" {
+		defer pushMetrics(c.PushGateway, "collie_cleanup")
+		return c.runOnce()
+	}
+
+	schedule, err := ParseSchedule(*c.Interval)
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to parse Interval")
+	}
+
+	return c.runScheduled(schedule)
+}
+
+func (c *CleanupCommand) isReady() bool {
+	c.readyMu.RLock()
+	defer c.readyMu.RUnlock()
+	return c.ready
+}
+
+func (c *CleanupCommand) setReady(ready bool) {
+	c.readyMu.Lock()
+	defer c.readyMu.Unlock()
+	c.ready = ready
+}
+
+// runOnce runs a single cleanup, holding the lease for the duration of the run when leader election is configured.
+func (c *CleanupCommand) runOnce() (err error) {
+	leaderElection := c.CleanupConfig.LeaderElection
+
+	if leaderElection == nil {
+		return c.runCleanup(context.Background())
+	}
+
+	if err = connectCluster(c.kubernetesManager, c.clusterConfig()); err != nil {
+		return err
+	}
+
+	var runErr error
+	if err = c.kubernetesManager.RunWithLeaderElection(context.Background(), leaderElection, func(ctx context.Context) {
+		runErr = c.runCleanup(ctx)
+	}); err != nil {
+		return errors.Wrap(err, "Failed leader election")
+	}
+
+	return runErr
+}
+
+// runScheduled runs cleanup on the schedule until interrupted. Runs never overlap,
+// the next run is scheduled once the previous one has finished. When leader election
+// is configured only the instance holding the lease runs cleanup, the others wait on standby.
+func (c *CleanupCommand) runScheduled(schedule cron.Schedule) (err error) {
+	mux := http.NewServeMux()
+	registerHealthHandlers(mux, c.isReady)
+	server := &http.Server{
+		Addr:    *c.HealthAddress,
+		Handler: mux,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Health server failed: %s", err)
+		}
+	}()
+	defer server.Shutdown(context.Background())
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			log.Printf("Stopping scheduled cleanup")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	leaderElection := c.CleanupConfig.LeaderElection
+
+	if leaderElection == nil {
+		c.runSchedule(ctx, schedule)
+		return nil
+	}
+
+	for ctx.Err() == nil {
+		if err := connectCluster(c.kubernetesManager, c.clusterConfig()); err != nil {
+			log.Printf("Failed to connect for leader election: %s", err)
+		} else if err := c.kubernetesManager.RunWithLeaderElection(ctx, leaderElection, func(leaderCtx context.Context) {
+			c.runSchedule(leaderCtx, schedule)
+		}); err != nil {
+			log.Printf("Failed leader election: %s", err)
+		}
+
+		c.setReady(false)
+
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+		}
+	}
+
+	return nil
+}
+
+func (c *CleanupCommand) runSchedule(ctx context.Context, schedule cron.Schedule) {
+	for {
+		changed, err := c.ReloadConfig(c.cleanupConfigPath)
+
+		switch {
+		case err != nil:
+			log.Printf("Failed to reload config, using previous config: %s", err)
+		case changed:
+			log.Printf("Loaded config %s", c.cleanupConfigPath)
+		}
+
+		if err := c.runCleanup(ctx); err != nil {
+			log.Printf("Cleanup failed: %s", err)
+			c.setReady(false)
+		} else {
+			c.setReady(true)
+		}
+
+		next := schedule.Next(time.Now())
+		log.Printf("Next cleanup at %s", next.Format(time.RFC3339))
+
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runCleanup cleans up each cluster, it stops before the next cluster, namespace or job once ctx is done, such as when
+// the lease is lost.
+func (c *CleanupCommand) runCleanup(ctx context.Context) (err error) {
+	branches, err := c.openBranches()
+	if err != nil {
+		return err
+	}
+
+	clusters := c.clusters()
+
+	if len(clusters) == 1 {
+		return c.cleanupCluster(ctx, clusters[0], branches).Err
+	}
+
+	var allErrs []string
+	for _, cluster := range clusters {
+		if err = ctx.Err(); err != nil {
+			return errors.Wrap(err, "Cleanup stopped")
+		}
+
+		result := c.cleanupCluster(ctx, cluster, branches)
+		result.Log()
+
+		if result.Err != nil {
+			allErrs = append(allErrs, fmt.Sprintf(" %d) %s: %s", len(allErrs), result.Name, result.Err))
+		}
+	}
+
+	if len(allErrs) > 0 {
+		allErr := errors.New(strings.Join(allErrs, ""))
+		return errors.Wrapf(allErr, "cleanup failed in %d of %d clusters", len(allErrs), len(clusters))
+	}
 
+	return
+}
+
+// openBranches returns the cleaned names of the branches with an open pull request.
+func (c *CleanupCommand) openBranches() (branches []string, err error) {
+	return openBranches(c.gitProviderFactory, c.CleanupConfig.GitProvider)
+}
+
+func openBranches(gitProviderFactory *external.GitProviderFactory, gitProvider *ConfigGitProvider) (branches []string, err error) {
 	var branchesRaw []string
 
 	switch {
-	case c.CleanupConfig.GitProvider.Bitbucket != nil:
+	case gitProvider == nil:
+		return nil, errors.New("No gitprovider found in configfile")
+	case gitProvider.Bitbucket != nil:
 		{
-			config := c.CleanupConfig.GitProvider.Bitbucket
+			config := gitProvider.Bitbucket
 
-			if _, err := c.gitProviderFactory.BitbucketManager.BasicAuth(config.ClientId, config.Secret); err != nil {
-				return errors.Wrap(err, "Failed to auth")
+			if _, err := gitProviderFactory.BitbucketManager.BasicAuth(config.ClientId, config.Secret); err != nil {
+				return nil, errors.Wrap(err, "Failed to auth")
 			}
 
-			if branchesRaw, err = c.gitProviderFactory.BitbucketManager.GetOpenPRBranches(config.Workspace, config.Repo); err != nil {
-				return errors.Wrap(err, "Failed to get branches")
+			if branchesRaw, err = gitProviderFactory.BitbucketManager.GetOpenPRBranches(config.Workspace, config.Repo); err != nil {
+				return nil, errors.Wrap(err, "Failed to get branches")
 			}
 		}
-	case c.CleanupConfig.GitProvider.Github != nil:
+	case gitProvider.Github != nil:
 		{
-			config := c.CleanupConfig.GitProvider.Github
+			config := gitProvider.Github
 
-			c.gitProviderFactory.GithubManager.BasicAuth(config.Username, config.Token)
+			gitProviderFactory.GithubManager.BasicAuth(config.Username, config.Token)
 
-			if branchesRaw, err = c.gitProviderFactory.GithubManager.GetOpenPRBranches(config.Organization, config.Repo); err != nil {
-				return errors.Wrap(err, "Failed to get branches")
+			if branchesRaw, err = gitProviderFactory.GithubManager.GetOpenPRBranches(config.Organization, config.Repo); err != nil {
+				return nil, errors.Wrap(err, "Failed to get branches")
 			}
 		}
 	default:
-		return errors.New("No gitprovider found in configfile")
+		return nil, errors.New("No gitprovider found in configfile")
 	}
 
-	var branches []string
-
 	for _, name := range branchesRaw {
 		branches = append(branches, CleanBranch(name))
 	}
 
-	var namespaces []string
+	return
+}
 
-	if c.CleanupConfig != nil && c.CleanupConfig.Kubeconfig != "" {
-		if _, err := c.kubernetesManager.OutClusterConfig(context.Background(), c.CleanupConfig.Kubeconfig); err != nil {
-			return errors.Wrap(err, "Failed to create outCluster config")
-		}
-	} else {
-		if _, err := c.kubernetesManager.InClusterConfig(context.Background()); err != nil {
-			return errors.Wrap(err, "Failed to create inCluster config")
-		}
+// cleanupCluster tears down the namespaces in the cluster that have no open pull request.
+func (c *CleanupCommand) cleanupCluster(ctx context.Context, cluster cleanupTarget, branches []string) (result ClusterResult) {
+	result.Name = cluster.Name
+
+	if result.Err = connectCluster(c.kubernetesManager, cluster.Cluster); result.Err != nil {
+		return
 	}
-	if namespaces, err = c.kubernetesManager.GetNamespaces(*c.NamespaceLabel); err != nil {
-		return errors.Wrap(err, "Failed to get namespaces")
+
+	namespaces, err := c.kubernetesManager.GetNamespaces(ctx, cluster.NamespaceLabel)
+	if err != nil {
+		result.Err = errors.Wrap(err, "Failed to get namespaces")
+		return
 	}
 
-	var cleanupList []string
+	metrics.NamespacesScanned.Add(float64(len(namespaces)))
+	result.Scanned = len(namespaces)
 
 	for _, name := range namespaces {
 		if !Contains(branches, name) {
-			cleanupList = append(cleanupList, name)
+			result.CleanedUp = append(result.CleanedUp, name)
 		}
 	}
 
-	log.Printf("Cleaning up %s", cleanupList)
+	log.Printf("Cleaning up %s", result.CleanedUp)
+
+	if cluster.Config.Mode == CleanupModeInProcess {
+		result.Err = cleanupInProcess(ctx, c.kubernetesManager, c.databaseManager, cluster.Config, result.CleanedUp)
+		return
+	}
+
+	result.Err = c.createCleanupJobs(ctx, cluster.Config.JobConfig, result.CleanedUp)
+	return
+}
 
-	jobConfig := c.CleanupConfig.JobConfig
+func (c *CleanupCommand) createCleanupJobs(ctx context.Context, jobConfig *external.CleanupJobConfig, cleanupList []string) (err error) {
 	var wg sync.WaitGroup
 	wg.Add(len(cleanupList))
 
 	createJob := func(name string, errs chan error) {
 		defer wg.Done()
 
-		if err := c.kubernetesManager.CreateCleanupJob(&external.CleanupJobConfig{
-			Name:             name,
-			Image:            jobConfig.Image,
-			ImagePullSecret:  jobConfig.ImagePullSecret,
-			JobNamespace:     jobConfig.JobNamespace,
-			ConnectionString: jobConfig.ConnectionString,
-		}); err != nil {
-			errs <- errors.Wrap(err, "Failed to create cleanupJob")
+		if err := ctx.Err(); err != nil {
+			errs <- errors.Wrapf(err, "Skipped cleanupJob for %s", name)
+			return
+		}
+
+		if err := createCleanupJob(ctx, c.kubernetesManager, jobConfig, name); err != nil {
+			errs <- err
 		}
 	}
 
@@ -200,6 +582,58 @@ func (c *CleanupCommand) Execute() (err error) {
 	return
 }
 
+// clusterConfig is the cluster from the config file with the --Context and --KubeNamespace flags applied.
+func (c *CleanupCommand) clusterConfig() external.ClusterConfig {
+	cluster := c.CleanupConfig.Cluster()
+
+	if c.Context != nil && *c.Context != "" {
+		cluster.Context = *c.Context
+	}
+
+	if c.KubeNamespace != nil && *c.KubeNamespace != "" {
+		cluster.Namespace = *c.KubeNamespace
+	}
+
+	return cluster
+}
+
+func connectCluster(kubernetesManager external.IKubernetesManager, cluster external.ClusterConfig) (err error) {
+	if err = kubernetesManager.Connect(context.Background(), cluster); err != nil {
+		return errors.Wrap(err, "Failed to connect to cluster")
+	}
+
+	return
+}
+
+func createCleanupJob(ctx context.Context, kubernetesManager external.IKubernetesManager, jobConfig *external.CleanupJobConfig, name string) (err error) {
+	if jobConfig == nil {
+		return errors.Errorf("Failed to create cleanupJob for %s, the config has no job section", name)
+	}
+
+	start := time.Now()
+
+	config := *jobConfig
+	config.Name = name
+
+	action, err := kubernetesManager.CreateCleanupJob(ctx, &config)
+
+	if err != nil {
+		metrics.CleanupJobDuration.WithLabelValues("failed").Observe(time.Since(start).Seconds())
+		metrics.CleanupJobFailures.Inc()
+		return errors.Wrap(err, "Failed to create cleanupJob")
+	}
+
+	log.Printf("Cleanup job for %s %s", name, action)
+
+	if action == external.CleanupJobSkipped {
+		return
+	}
+
+	metrics.CleanupJobDuration.WithLabelValues("succeeded").Observe(time.Since(start).Seconds())
+	metrics.NamespacesDeleted.Inc()
+	return
+}
+
 func Contains(arr []string, str string) bool {
 	for _, val := range arr {
 		if val == str {
@@ -2,6 +2,7 @@ package command_test
 
 import (
 	"testing"
+	"time"
 
 	"bitbucket.org/centeva/collie/packages/command"
 	"bitbucket.org/centeva/collie/packages/external"
@@ -16,7 +17,7 @@ func Test_ExecuteBasicAuth(t *testing.T) {
 	}
 	mockKubernetesManager := testutils.NewMockKubernetesManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, mockFileReader, mockGitProviderFactory)
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), mockFileReader, mockGitProviderFactory)
 
 	bitbucketArgs := &command.ConfigBitbucketArgs{
 		ClientId:  "testClientId",
@@ -68,7 +69,7 @@ func Test_ExecuteGetOpenPRBranches(t *testing.T) {
 	}
 	mockKubernetesManager := testutils.NewMockKubernetesManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, mockFileReader, mockGitProviderFactory)
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), mockFileReader, mockGitProviderFactory)
 
 	bitbucketArgs := &command.ConfigBitbucketArgs{
 		ClientId:  "testClientId",
@@ -112,7 +113,7 @@ func Test_ExecuteGetOpenPRBranches(t *testing.T) {
 	}
 }
 
-func Test_ExecuteOutClusterConfig(t *testing.T) {
+func Test_ExecuteConnectKubeconfig(t *testing.T) {
 	mockFileReader := testutils.NewMockFileReader("testFile")
 	mockBitbucketManager := testutils.NewMockGitProvider()
 	mockGitProviderFactory := &external.GitProviderFactory{
@@ -120,7 +121,7 @@ func Test_ExecuteOutClusterConfig(t *testing.T) {
 	}
 	mockKubernetesManager := testutils.NewMockKubernetesManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, mockFileReader, mockGitProviderFactory)
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), mockFileReader, mockGitProviderFactory)
 
 	bitbucketArgs := &command.ConfigBitbucketArgs{
 		ClientId:  "testClientId",
@@ -146,22 +147,17 @@ func Test_ExecuteOutClusterConfig(t *testing.T) {
 	sut.NamespaceLabel = &namespaceLabel
 	sut.Execute()
 
-	if mockKubernetesManager.Called["outclusterconfig"] != 1 {
-		t.Errorf("OutClusterConfig() should have been called once")
+	if mockKubernetesManager.Called["connect"] != 1 {
+		t.Fatalf("Connect() should have been called once")
 	}
 
-	firstArgs := mockKubernetesManager.CalledWith["outclusterconfig"][0]
-	switch args := firstArgs.(type) {
-	case *testutils.KMOutClusterConfigArgs:
-		flat := *args
-
-		if flat.Kubeconfig != cleanupConfig.Kubeconfig {
-			t.Errorf("OutClusterConfig() should have been called with Kubeconfig: %s but got %+v", cleanupConfig.Kubeconfig, firstArgs)
-		}
+	args := mockKubernetesManager.CalledWith["connect"][0].(*testutils.KMConnectArgs)
+	if args.Cluster.Kubeconfig != cleanupConfig.Kubeconfig {
+		t.Errorf("Connect() should have been called with Kubeconfig: %s but got %+v", cleanupConfig.Kubeconfig, args.Cluster)
 	}
 }
 
-func Test_ExecuteInClusterConfig(t *testing.T) {
+func Test_ExecuteConnectContextFlags(t *testing.T) {
 	mockFileReader := testutils.NewMockFileReader("testFile")
 	mockBitbucketManager := testutils.NewMockGitProvider()
 	mockGitProviderFactory := &external.GitProviderFactory{
@@ -169,7 +165,7 @@ func Test_ExecuteInClusterConfig(t *testing.T) {
 	}
 	mockKubernetesManager := testutils.NewMockKubernetesManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, mockFileReader, mockGitProviderFactory)
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), mockFileReader, mockGitProviderFactory)
 
 	bitbucketArgs := &command.ConfigBitbucketArgs{
 		ClientId:  "testClientId",
@@ -184,19 +180,29 @@ func Test_ExecuteInClusterConfig(t *testing.T) {
 	jobConfig := &external.CleanupJobConfig{}
 
 	cleanupConfig := &command.CleanupConfig{
-		Kubeconfig:  "",
-		GitProvider: gitProvider,
-		JobConfig:   jobConfig,
+		Context:       "configContext",
+		KubeNamespace: "configNamespace",
+		GitProvider:   gitProvider,
+		JobConfig:     jobConfig,
 	}
 
 	namespaceLabel := "testLabel"
+	kubeContext := "flagContext"
+	kubeNamespace := ""
 
 	sut.CleanupConfig = cleanupConfig
 	sut.NamespaceLabel = &namespaceLabel
+	sut.Context = &kubeContext
+	sut.KubeNamespace = &kubeNamespace
 	sut.Execute()
 
-	if mockKubernetesManager.Called["inclusterconfig"] != 1 {
-		t.Errorf("InClusterConfig() should have been called once")
+	if mockKubernetesManager.Called["connect"] != 1 {
+		t.Fatalf("Connect() should have been called once")
+	}
+
+	want := external.ClusterConfig{Context: "flagContext", Namespace: "configNamespace"}
+	if args := mockKubernetesManager.CalledWith["connect"][0].(*testutils.KMConnectArgs); args.Cluster != want {
+		t.Errorf("Connect() should have been called with %+v but got %+v", want, args.Cluster)
 	}
 }
 
@@ -208,7 +214,7 @@ func Test_ExecuteGetNamespaces(t *testing.T) {
 	}
 	mockKubernetesManager := testutils.NewMockKubernetesManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, mockFileReader, mockGitProviderFactory)
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), mockFileReader, mockGitProviderFactory)
 
 	bitbucketArgs := &command.ConfigBitbucketArgs{
 		ClientId:  "testClientId",
@@ -259,7 +265,7 @@ func Test_ExecuteCreateCleanupJob(t *testing.T) {
 	mockKubernetesManager.GetNamespacesRes = []string{"test-1"}
 	mockBitbucketManager.GetBranchesRes = []string{"test-2"}
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, mockFileReader, mockGitProviderFactory)
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), mockFileReader, mockGitProviderFactory)
 
 	bitbucketArgs := &command.ConfigBitbucketArgs{
 		ClientId:  "testClientId",
@@ -316,3 +322,445 @@ func Test_ExecuteCreateCleanupJob(t *testing.T) {
 		}
 	}
 }
+
+func Test_ReloadConfig(t *testing.T) {
+	mockFileReader := testutils.NewMockFileReader("job:\n  image: collie\nkubeconfig: first\n")
+	mockFlagProvider := testutils.NewMockFlagProvider()
+	sut := command.NewCleanupCommand(mockFlagProvider, testutils.NewMockKubernetesManager(), testutils.NewMockDatabaseManager(), mockFileReader, &external.GitProviderFactory{})
+
+	changed, err := sut.ReloadConfig("config.yaml")
+	if err != nil || !changed {
+		t.Fatalf("ReloadConfig() should load the initial config; changed: %v err: %s", changed, err)
+	}
+
+	changed, err = sut.ReloadConfig("config.yaml")
+	if err != nil || changed {
+		t.Errorf("ReloadConfig() should not report a change for the same file; changed: %v err: %s", changed, err)
+	}
+
+	mockFileReader.ReadFileRes = []byte("job:\n  image: collie\nkubeconfig: second\n")
+	changed, err = sut.ReloadConfig("config.yaml")
+	if err != nil || !changed {
+		t.Errorf("ReloadConfig() should report a change; changed: %v err: %s", changed, err)
+	}
+
+	if sut.CleanupConfig.Kubeconfig != "second" {
+		t.Errorf("ReloadConfig() should replace CleanupConfig, got Kubeconfig: %s", sut.CleanupConfig.Kubeconfig)
+	}
+
+	mockFileReader.ReadFileRes = []byte("kubeconfig: [")
+	if _, err = sut.ReloadConfig("config.yaml"); err == nil {
+		t.Errorf("ReloadConfig() should error on an invalid file")
+	}
+
+	if sut.CleanupConfig.Kubeconfig != "second" {
+		t.Errorf("ReloadConfig() should keep the previous config on error, got Kubeconfig: %s", sut.CleanupConfig.Kubeconfig)
+	}
+}
+
+func Test_ExecuteLeaderElection(t *testing.T) {
+	mockFileReader := testutils.NewMockFileReader("testFile")
+	mockBitbucketManager := testutils.NewMockGitProvider()
+	mockGitProviderFactory := &external.GitProviderFactory{
+		BitbucketManager: mockBitbucketManager,
+	}
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"test-1"}
+	mockFlagProvider := testutils.NewMockFlagProvider()
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), mockFileReader, mockGitProviderFactory)
+
+	leaderElection := &external.LeaderElectionConfig{
+		Name:      "collie-cleanup",
+		Namespace: "testJobNamespace",
+	}
+
+	sut.CleanupConfig = &command.CleanupConfig{
+		Kubeconfig: "kubeconfig",
+		GitProvider: &command.ConfigGitProvider{
+			Bitbucket: &command.ConfigBitbucketArgs{},
+		},
+		JobConfig:      &external.CleanupJobConfig{},
+		LeaderElection: leaderElection,
+	}
+
+	namespaceLabel := "testLabel"
+	sut.NamespaceLabel = &namespaceLabel
+	sut.Execute()
+
+	if mockKubernetesManager.Called["runwithleaderelection"] != 1 {
+		t.Fatalf("RunWithLeaderElection() should have been called once")
+	}
+
+	args := mockKubernetesManager.CalledWith["runwithleaderelection"][0].(*testutils.KMRunWithLeaderElectionArgs)
+	if args.Config != leaderElection {
+		t.Errorf("RunWithLeaderElection() should have been called with Config: %+v but got %+v", leaderElection, args.Config)
+	}
+
+	if mockKubernetesManager.Called["createcleanupjob"] != 1 {
+		t.Errorf("CreateCleanupJob() should have been called once while holding the lease")
+	}
+}
+
+func Test_ExecuteLeaderElectionLostLease(t *testing.T) {
+	tests := []struct {
+		name string
+		mode command.CleanupMode
+	}{
+		{name: "job", mode: command.CleanupModeJob},
+		{name: "inProcess", mode: command.CleanupModeInProcess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockGitProviderFactory := &external.GitProviderFactory{
+				BitbucketManager: testutils.NewMockGitProvider(),
+			}
+			mockKubernetesManager := testutils.NewMockKubernetesManager()
+			mockKubernetesManager.GetNamespacesRes = []string{"test-1", "test-2"}
+			mockKubernetesManager.LoseLease = true
+			mockDatabaseManager := testutils.NewMockDatabaseManager()
+			sut := command.NewCleanupCommand(testutils.NewMockFlagProvider(), mockKubernetesManager, mockDatabaseManager, testutils.NewMockFileReader("testFile"), mockGitProviderFactory)
+
+			sut.CleanupConfig = &command.CleanupConfig{
+				Mode: tt.mode,
+				GitProvider: &command.ConfigGitProvider{
+					Bitbucket: &command.ConfigBitbucketArgs{},
+				},
+				JobConfig:      &external.CleanupJobConfig{ConnectionString: "postgres://localhost/postgres"},
+				LeaderElection: &external.LeaderElectionConfig{Name: "collie-cleanup"},
+			}
+
+			namespaceLabel := "testLabel"
+			sut.NamespaceLabel = &namespaceLabel
+
+			if err := sut.Execute(); err == nil {
+				t.Errorf("Execute() should error when the lease is lost")
+			}
+
+			if mockKubernetesManager.Called["createcleanupjob"] != 0 {
+				t.Errorf("CreateCleanupJob() should not be called after the lease is lost, got %d calls", mockKubernetesManager.Called["createcleanupjob"])
+			}
+
+			if mockKubernetesManager.Called["deletenamespace"] != 0 || mockDatabaseManager.Called["deletedatabase"] != 0 {
+				t.Errorf("Namespaces and databases should not be deleted after the lease is lost")
+			}
+		})
+	}
+}
+
+func Test_ExecuteLeaderElectionLeaseLostDuringJob(t *testing.T) {
+	mockGitProviderFactory := &external.GitProviderFactory{
+		BitbucketManager: testutils.NewMockGitProvider(),
+	}
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"test-1"}
+	mockKubernetesManager.CreateCleanupJobBlocks = true
+	mockKubernetesManager.LoseLeaseAfter = 50 * time.Millisecond
+	sut := command.NewCleanupCommand(testutils.NewMockFlagProvider(), mockKubernetesManager, testutils.NewMockDatabaseManager(), testutils.NewMockFileReader("testFile"), mockGitProviderFactory)
+
+	sut.CleanupConfig = &command.CleanupConfig{
+		GitProvider: &command.ConfigGitProvider{
+			Bitbucket: &command.ConfigBitbucketArgs{},
+		},
+		JobConfig:      &external.CleanupJobConfig{Image: "testImage"},
+		LeaderElection: &external.LeaderElectionConfig{Name: "collie-cleanup"},
+	}
+
+	namespaceLabel := "testLabel"
+	sut.NamespaceLabel = &namespaceLabel
+
+	done := make(chan error, 1)
+	go func() { done <- sut.Execute() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Execute() should error when the lease is lost")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Execute() should stop the cleanup job once the lease is lost")
+	}
+
+	if mockKubernetesManager.Called["createcleanupjobreturned"] != 1 {
+		t.Errorf("CreateCleanupJob() should get the leader election context, got %d stopped calls", mockKubernetesManager.Called["createcleanupjobreturned"])
+	}
+}
+
+func Test_ExecuteCreateCleanupJobPolicy(t *testing.T) {
+	mockFileReader := testutils.NewMockFileReader("testFile")
+	mockBitbucketManager := testutils.NewMockGitProvider()
+	mockGitProviderFactory := &external.GitProviderFactory{
+		BitbucketManager: mockBitbucketManager,
+	}
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"test-1"}
+	mockKubernetesManager.CreateCleanupJobRes = external.CleanupJobSkipped
+	mockFlagProvider := testutils.NewMockFlagProvider()
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), mockFileReader, mockGitProviderFactory)
+
+	jobConfig := &external.CleanupJobConfig{
+		Timeout:           "5m",
+		ExistingJobPolicy: external.ExistingJobSkip,
+	}
+
+	sut.CleanupConfig = &command.CleanupConfig{
+		Kubeconfig: "kubeconfig",
+		GitProvider: &command.ConfigGitProvider{
+			Bitbucket: &command.ConfigBitbucketArgs{},
+		},
+		JobConfig: jobConfig,
+	}
+
+	namespaceLabel := "testLabel"
+	sut.NamespaceLabel = &namespaceLabel
+
+	if err := sut.Execute(); err != nil {
+		t.Errorf("Execute() should not error when the job is skipped, got %s", err)
+	}
+
+	args := mockKubernetesManager.CalledWith["createcleanupjob"][0].(*testutils.KMCreateCleanupJobArgs)
+	if args.Config.ExistingJobPolicy != external.ExistingJobSkip {
+		t.Errorf("CreateCleanupJob() should have been called with ExistingJobPolicy: %s but got %+v", external.ExistingJobSkip, args.Config)
+	}
+	if args.Config.Timeout != jobConfig.Timeout {
+		t.Errorf("CreateCleanupJob() should have been called with Timeout: %s but got %+v", jobConfig.Timeout, args.Config)
+	}
+	if args.Config == jobConfig {
+		t.Errorf("CreateCleanupJob() should be called with a copy of the job config")
+	}
+}
+
+func Test_ReloadConfigJobTemplate(t *testing.T) {
+	mockFileReader := testutils.NewMockFileReader(`
+job:
+  image: testImage
+  template: job-template.yaml
+  overrides:
+    backoffLimit: 0
+    nodeSelector:
+      pool: tools
+    resources:
+      limits:
+        memory: 128Mi
+    envFrom:
+      - secretRef:
+          name: testSecret
+`)
+	mockFileReader.Files["config/job-template.yaml"] = []byte(`
+apiVersion: v1
+kind: PodTemplate
+template:
+  spec:
+    containers:
+      - name: teardown
+        resources:
+          requests:
+            cpu: 50m
+`)
+	mockFlagProvider := testutils.NewMockFlagProvider()
+	sut := command.NewCleanupCommand(mockFlagProvider, testutils.NewMockKubernetesManager(), testutils.NewMockDatabaseManager(), mockFileReader, &external.GitProviderFactory{})
+
+	if _, err := sut.ReloadConfig("config/cleanup.yaml"); err != nil {
+		t.Fatalf("ReloadConfig() should not error, got %s", err)
+	}
+
+	jobConfig := sut.CleanupConfig.JobConfig
+
+	if jobConfig.Template == nil || len(jobConfig.Template.Spec.Template.Spec.Containers) != 1 {
+		t.Fatalf("ReloadConfig() should load the job template relative to the config, got %+v", jobConfig.Template)
+	}
+
+	overrides := jobConfig.Overrides
+	if overrides == nil {
+		t.Fatalf("ReloadConfig() should load job overrides")
+	}
+	if overrides.BackoffLimit == nil || *overrides.BackoffLimit != 0 {
+		t.Errorf("ReloadConfig() should load backoffLimit: 0 got %v", overrides.BackoffLimit)
+	}
+	if overrides.NodeSelector["pool"] != "tools" {
+		t.Errorf("ReloadConfig() should load nodeSelector got %v", overrides.NodeSelector)
+	}
+	if memory := overrides.Resources.Limits.Memory().String(); memory != "128Mi" {
+		t.Errorf("ReloadConfig() should load resources got memory limit %s", memory)
+	}
+	if len(overrides.EnvFrom) != 1 || overrides.EnvFrom[0].SecretRef.Name != "testSecret" {
+		t.Errorf("ReloadConfig() should load envFrom got %+v", overrides.EnvFrom)
+	}
+}
+
+func Test_ReloadConfigJobTemplateChanged(t *testing.T) {
+	mockFileReader := testutils.NewMockFileReader(`
+job:
+  image: testImage
+  template: job-template.yaml
+`)
+	template := func(cpu string) []byte {
+		return []byte(`
+kind: PodTemplate
+template:
+  spec:
+    containers:
+      - name: teardown
+        resources:
+          requests:
+            cpu: ` + cpu + `
+`)
+	}
+	mockFileReader.Files["config/job-template.yaml"] = template("50m")
+	sut := command.NewCleanupCommand(testutils.NewMockFlagProvider(), testutils.NewMockKubernetesManager(), testutils.NewMockDatabaseManager(), mockFileReader, &external.GitProviderFactory{})
+
+	if changed, err := sut.ReloadConfig("config/cleanup.yaml"); !changed || err != nil {
+		t.Fatalf("ReloadConfig() should load the initial config; changed: %v err: %v", changed, err)
+	}
+
+	if changed, err := sut.ReloadConfig("config/cleanup.yaml"); changed || err != nil {
+		t.Errorf("ReloadConfig() should not report a change when neither file changed; changed: %v err: %v", changed, err)
+	}
+
+	mockFileReader.Files["config/job-template.yaml"] = template("100m")
+
+	if changed, err := sut.ReloadConfig("config/cleanup.yaml"); !changed || err != nil {
+		t.Fatalf("ReloadConfig() should report a change of the job template; changed: %v err: %v", changed, err)
+	}
+
+	containers := sut.CleanupConfig.JobConfig.Template.Spec.Template.Spec.Containers
+	if cpu := containers[0].Resources.Requests.Cpu().String(); cpu != "100m" {
+		t.Errorf("ReloadConfig() should load the changed job template, got cpu request %s", cpu)
+	}
+}
+
+func Test_ExecuteInProcess(t *testing.T) {
+	mockFileReader := testutils.NewMockFileReader("testFile")
+	mockBitbucketManager := testutils.NewMockGitProvider()
+	mockBitbucketManager.GetBranchesRes = []string{"test-2"}
+	mockGitProviderFactory := &external.GitProviderFactory{
+		BitbucketManager: mockBitbucketManager,
+	}
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"test-1", "test-2"}
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockFlagProvider := testutils.NewMockFlagProvider()
+	sut := command.NewCleanupCommand(mockFlagProvider, mockKubernetesManager, mockDatabaseManager, mockFileReader, mockGitProviderFactory)
+
+	sut.CleanupConfig = &command.CleanupConfig{
+		Kubeconfig: "kubeconfig",
+		Mode:       command.CleanupModeInProcess,
+		GitProvider: &command.ConfigGitProvider{
+			Bitbucket: &command.ConfigBitbucketArgs{},
+		},
+		JobConfig: &external.CleanupJobConfig{
+			ConnectionString: "testConnectionString",
+		},
+	}
+
+	namespaceLabel := "testLabel"
+	sut.NamespaceLabel = &namespaceLabel
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if mockKubernetesManager.Called["createcleanupjob"] != 0 {
+		t.Errorf("CreateCleanupJob() should not have been called")
+	}
+
+	if mockDatabaseManager.Called["connect"] != 1 || mockDatabaseManager.Called["close"] != 1 {
+		t.Errorf("Connect() and Close() should have been called once, got %v", mockDatabaseManager.Called)
+	}
+
+	if mockKubernetesManager.Called["deletenamespace"] != 1 {
+		t.Fatalf("DeleteNamespace() should have been called once")
+	}
+
+	if args := mockKubernetesManager.CalledWith["deletenamespace"][0].(*testutils.KMDeleteNamespaceArgs); args.Namespace != "test-1" {
+		t.Errorf("DeleteNamespace() should have been called with Namespace: test-1 but got %+v", args)
+	}
+
+	if mockDatabaseManager.Called["deletedatabase"] != 1 {
+		t.Fatalf("DeleteDatabase() should have been called once")
+	}
+
+	if args := mockDatabaseManager.CalledWith["deletedatabase"][0].(*testutils.DMDeleteDatabaseArgs); args.Database != "test-1" {
+		t.Errorf("DeleteDatabase() should have been called with Database: test-1 but got %+v", args)
+	}
+}
+
+func Test_ExecuteMultiCluster(t *testing.T) {
+	mockBitbucketManager := testutils.NewMockGitProvider()
+	mockBitbucketManager.GetBranchesRes = []string{"test-2"}
+	mockGitProviderFactory := &external.GitProviderFactory{
+		BitbucketManager: mockBitbucketManager,
+	}
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"test-1", "test-2"}
+	sut := command.NewCleanupCommand(testutils.NewMockFlagProvider(), mockKubernetesManager, testutils.NewMockDatabaseManager(), testutils.NewMockFileReader("testFile"), mockGitProviderFactory)
+
+	sut.CleanupConfig = &command.CleanupConfig{
+		GitProvider: &command.ConfigGitProvider{
+			Bitbucket: &command.ConfigBitbucketArgs{},
+		},
+		JobConfig: &external.CleanupJobConfig{
+			JobNamespace: "default",
+		},
+		Clusters: []command.ConfigCluster{
+			{Name: "dev", Context: "dev-context"},
+			{Name: "qa", Context: "qa-context", NamespaceLabel: "qaLabel", JobNamespace: "qa-jobs"},
+		},
+	}
+
+	namespaceLabel := "testLabel"
+	sut.NamespaceLabel = &namespaceLabel
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if mockBitbucketManager.Called["getopenprbranches"] != 1 {
+		t.Errorf("GetOpenPRBranches() should be called once for all clusters, got %d", mockBitbucketManager.Called["getopenprbranches"])
+	}
+
+	if mockKubernetesManager.Called["connect"] != 2 {
+		t.Fatalf("Connect() should have been called once per cluster, got %d", mockKubernetesManager.Called["connect"])
+	}
+
+	for i, context := range []string{"dev-context", "qa-context"} {
+		if args := mockKubernetesManager.CalledWith["connect"][i].(*testutils.KMConnectArgs); args.Cluster.Context != context {
+			t.Errorf("Connect() call %d should use context %s, got %+v", i, context, args.Cluster)
+		}
+	}
+
+	for i, label := range []string{"testLabel", "qaLabel"} {
+		if args := mockKubernetesManager.CalledWith["getnamespaces"][i].(*testutils.KMGetNamespacesArgs); args.Label != label {
+			t.Errorf("GetNamespaces() call %d should use label %s, got %s", i, label, args.Label)
+		}
+	}
+
+	if mockKubernetesManager.Called["createcleanupjob"] != 2 {
+		t.Fatalf("CreateCleanupJob() should have been called once per cluster, got %d", mockKubernetesManager.Called["createcleanupjob"])
+	}
+
+	for i, namespace := range []string{"default", "qa-jobs"} {
+		if args := mockKubernetesManager.CalledWith["createcleanupjob"][i].(*testutils.KMCreateCleanupJobArgs); args.Config.JobNamespace != namespace || args.Config.Name != "test-1" {
+			t.Errorf("CreateCleanupJob() call %d should create test-1 in %s, got %+v", i, namespace, args.Config)
+		}
+	}
+
+	if sut.CleanupConfig.JobConfig.JobNamespace != "default" {
+		t.Errorf("Cluster overrides should not change the shared job config, got %s", sut.CleanupConfig.JobConfig.JobNamespace)
+	}
+}
+
+func Test_ReloadConfigDuplicateClusters(t *testing.T) {
+	mockFileReader := testutils.NewMockFileReader("")
+	mockFileReader.Files["cleanup.yaml"] = []byte(`
+clusters:
+  - context: dev
+  - name: dev
+    context: other
+`)
+	sut := command.NewCleanupCommand(testutils.NewMockFlagProvider(), testutils.NewMockKubernetesManager(), testutils.NewMockDatabaseManager(), mockFileReader, &external.GitProviderFactory{})
+
+	if _, err := sut.ReloadConfig("cleanup.yaml"); err == nil {
+		t.Errorf("ReloadConfig() should error when two clusters have the same name")
+	}
+}
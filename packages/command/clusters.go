@@ -0,0 +1,125 @@
+package command
+
+import (
+	"fmt"
+	"log"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"github.com/pkg/errors"
+)
+
+// ConfigCluster is one of several clusters Cleanup compares the open pull requests against. Empty settings
+// fall back to the top level ones.
+type ConfigCluster struct {
+	Name             string `yaml:"name"`
+	Kubeconfig       string `yaml:"kubeconfig"`
+	Context          string `yaml:"context"`
+	KubeNamespace    string `yaml:"kubeNamespace"`
+	NamespaceLabel   string `yaml:"namespaceLabel"`
+	JobNamespace     string `yaml:"jobNamespace"`
+	ConnectionString string `yaml:"connectionString"`
+}
+
+// ClusterResult is the outcome of cleaning up one cluster.
+type ClusterResult struct {
+	Name      string
+	Scanned   int
+	CleanedUp []string
+	Err       error
+}
+
+func (r ClusterResult) Log() {
+	if r.Err != nil {
+		log.Printf("Cluster %s: scanned %d namespaces, cleanup of %d failed: %s", r.Name, r.Scanned, len(r.CleanedUp), r.Err)
+		return
+	}
+
+	log.Printf("Cluster %s: scanned %d namespaces, cleaned up %d %s", r.Name, r.Scanned, len(r.CleanedUp), r.CleanedUp)
+}
+
+// cleanupTarget is a cluster with the config to clean it up with.
+type cleanupTarget struct {
+	Name           string
+	Cluster        external.ClusterConfig
+	NamespaceLabel string
+	Config         *CleanupConfig
+}
+
+// clusters returns the configured clusters, or the top level cluster when none are configured.
+func (c *CleanupCommand) clusters() (targets []cleanupTarget) {
+	if len(c.CleanupConfig.Clusters) == 0 {
+		return []cleanupTarget{
+			{
+				Cluster:        c.clusterConfig(),
+				NamespaceLabel: *c.NamespaceLabel,
+				Config:         c.CleanupConfig,
+			},
+		}
+	}
+
+	for _, cluster := range c.CleanupConfig.Clusters {
+		target := cleanupTarget{
+			Name: cluster.Name,
+			Cluster: external.ClusterConfig{
+				Kubeconfig: cluster.Kubeconfig,
+				Context:    cluster.Context,
+				Namespace:  cluster.KubeNamespace,
+			},
+			NamespaceLabel: cluster.NamespaceLabel,
+			Config:         c.CleanupConfig,
+		}
+
+		if target.NamespaceLabel == "" {
+			target.NamespaceLabel = *c.NamespaceLabel
+		}
+
+		if cluster.JobNamespace != "" || cluster.ConnectionString != "" {
+			config := *c.CleanupConfig
+			jobConfig := external.CleanupJobConfig{}
+			if config.JobConfig != nil {
+				jobConfig = *config.JobConfig
+			}
+
+			if cluster.JobNamespace != "" {
+				jobConfig.JobNamespace = cluster.JobNamespace
+			}
+
+			if cluster.ConnectionString != "" {
+				jobConfig.ConnectionString = cluster.ConnectionString
+				jobConfig.ConnectionStringSecret = nil
+			}
+
+			config.JobConfig = &jobConfig
+			target.Config = &config
+		}
+
+		targets = append(targets, target)
+	}
+
+	return
+}
+
+// validateClusters names unnamed clusters after their context and checks the names are unique.
+func validateClusters(clusters []ConfigCluster) (err error) {
+	names := map[string]bool{}
+
+	for i := range clusters {
+		cluster := &clusters[i]
+
+		if cluster.Name == "" {
+			cluster.Name = cluster.Context
+		}
+
+		if cluster.Name == "" {
+			cluster.Name = fmt.Sprintf("cluster-%d", i)
+		}
+
+		if names[cluster.Name] {
+			return errors.Errorf("Cluster name '%s' is used more than once", cluster.Name)
+		}
+
+		names[cluster.Name] = true
+	}
+
+	return
+}
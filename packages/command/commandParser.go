@@ -1,7 +1,10 @@
 package command
 
 import (
+	"flag"
+	"log"
 	"os"
+	"strings"
 
 	"bitbucket.org/centeva/collie/packages/external"
 	"github.com/pkg/errors"
@@ -15,9 +18,11 @@ const (
 )
 
 type ICommand interface {
-	GetFlags() (err error)
+	// Names are the name of the command followed by its aliases, they are matched case insensitively.
+	Names() []string
+	// GetFlags parses the arguments after the command name, it returns flag.ErrHelp when they ask for the usage.
+	GetFlags(args []string) (err error)
 	Execute() (err error)
-	IsCurrent() bool
 }
 
 type CommandParser struct {
@@ -26,7 +31,7 @@ type CommandParser struct {
 	commands          []ICommand
 }
 
-func NewCommandParser(flagProvider external.IFlagProvider, gitProviderFactory *external.GitProviderFactory, kubernetesManager external.IKubernetesManager, postgresManager external.IPostgresManager, fileReader external.IFileReader) *CommandParser {
+func NewCommandParser(flagProvider external.IFlagProvider, gitProviderFactory *external.GitProviderFactory, kubernetesManager external.IKubernetesManager, databaseManager external.IDatabaseManager, fileReader external.IFileReader) *CommandParser {
 	parser := &CommandParser{
 		flagProvider:      flagProvider,
 		kubernetesManager: kubernetesManager,
@@ -34,39 +39,74 @@ func NewCommandParser(flagProvider external.IFlagProvider, gitProviderFactory *e
 			NewCleanBranchCommand(flagProvider),
 			NewPRCommentCommand(flagProvider, gitProviderFactory),
 			NewNamespaceCommand(flagProvider, kubernetesManager),
-			NewDatabaseCommand(flagProvider, postgresManager),
-			NewCleanupCommand(flagProvider, kubernetesManager, fileReader, gitProviderFactory),
+			NewDatabaseCommand(flagProvider, databaseManager, fileReader),
+			NewCreateDatabaseCommand(flagProvider, kubernetesManager, databaseManager, fileReader),
+			NewPruneDatabasesCommand(flagProvider, kubernetesManager, databaseManager, fileReader, gitProviderFactory),
+			NewCleanupCommand(flagProvider, kubernetesManager, databaseManager, fileReader, gitProviderFactory),
+			NewServeCommand(flagProvider, kubernetesManager, databaseManager, fileReader),
+			NewTeardownCommand(flagProvider, kubernetesManager, databaseManager, fileReader),
 			NewHelpCommand(flagProvider),
 		},
 	}
 	return parser
 }
 
+// ParseCommands runs the command named by the first command line argument.
 func (parser CommandParser) ParseCommands() (err error) {
+	return parser.Run(os.Args[1:])
+}
 
-	if len(os.Args) < 1 || os.Args[0] == "" {
+// Run runs the command named by the first arg with the rest of the args. `<command> --help` and `Help <command>`
+// print the usage of the command instead.
+func (parser CommandParser) Run(args []string) (err error) {
+	if len(args) == 0 || args[0] == "" {
+		parser.printHelp()
 		return errors.New("Must specify a command to run")
 	}
 
-	for _, c := range parser.commands {
-		if c.IsCurrent() {
-			if err := c.GetFlags(); err != nil {
-				return errors.Wrapf(err, "Failed to get flags for command: %T", c)
-			}
-
-			if err = c.Execute(); err != nil {
-				return errors.Wrap(err, "Failed to execute command")
-			}
+	c := parser.find(args[0])
+	if c == nil {
+		parser.printHelp()
+		return errors.Errorf("Unknown command %s", args[0])
+	}
 
-			return
+	if _, isHelp := c.(*HelpCommand); isHelp && len(args) > 1 {
+		if c = parser.find(args[1]); c == nil {
+			return errors.Errorf("Unknown command %s", args[1])
 		}
+
+		args = []string{args[1], "--help"}
 	}
 
-	help := NewHelpCommand(parser.flagProvider)
+	if err = c.GetFlags(args[1:]); err != nil {
+		if errors.Cause(err) == flag.ErrHelp {
+			return nil
+		}
+
+		return errors.Wrapf(err, "Failed to get flags for command: %s", c.Names()[0])
+	}
 
-	if err = help.Execute(); err != nil {
-		return errors.Wrap(err, "Failed to output help")
+	if err = c.Execute(); err != nil {
+		return errors.Wrap(err, "Failed to execute command")
 	}
 
 	return
 }
+
+func (parser CommandParser) find(name string) ICommand {
+	for _, c := range parser.commands {
+		for _, commandName := range c.Names() {
+			if strings.EqualFold(commandName, name) {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+func (parser CommandParser) printHelp() {
+	if err := NewHelpCommand(parser.flagProvider).Execute(); err != nil {
+		log.Printf("Failed to output help: %s", err)
+	}
+}
@@ -1,6 +1,8 @@
 package command_test
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"bitbucket.org/centeva/collie/packages/command"
@@ -21,3 +23,68 @@ func Test_ParseCommands(t *testing.T) {
 		t.Errorf("ParseFlags(): flagProvider.Parse() Should not have been called; got: %v", flagProvider.Called)
 	}
 }
+
+func commandParserTestSetup() (*command.CommandParser, *testutils.MockDatabaseManager) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	gitProviderFactory := &external.GitProviderFactory{BitbucketManager: &testutils.MockGitProvider{}}
+	sut := command.NewCommandParser(external.NewFlagProvider(), gitProviderFactory, testutils.NewMockKubernetesManager(), mockDatabaseManager, testutils.NewMockFileReader(""))
+	return sut, mockDatabaseManager
+}
+
+func Test_RunAliasWithPositionalArgsAnywhere(t *testing.T) {
+	sut, mockDatabaseManager := commandParserTestSetup()
+
+	err := sut.Run([]string{"delete-database", "pr-1", "--ConnectionString", "postgres://db", "pr-2", "--Timeout=30s", "pr-3"})
+
+	if err != nil {
+		t.Fatalf("Run() should not error, got %s", err)
+	}
+
+	if connect := mockDatabaseManager.CalledWith["connect"][0].(*testutils.DMConnectArgs); connect.ConnectionString != "postgres://db" {
+		t.Errorf("Run() should pass the flags to the command, got %+v", connect)
+	}
+
+	if deleted := deletedDatabases(mockDatabaseManager); !reflect.DeepEqual(deleted, []string{"pr-1", "pr-2", "pr-3"}) {
+		t.Errorf("Run() should pass every positional arg to the command, got %v", deleted)
+	}
+}
+
+func Test_RunHelp(t *testing.T) {
+	for _, args := range [][]string{
+		{"DeleteDatabase", "--help"},
+		{"deletedatabase", "pr-1", "-h"},
+		{"Help", "delete-database"},
+		{"Help"},
+	} {
+		sut, mockDatabaseManager := commandParserTestSetup()
+
+		if err := sut.Run(args); err != nil {
+			t.Errorf("Run(%v) should print the usage without error, got %s", args, err)
+		}
+
+		if mockDatabaseManager.Called["connect"] != 0 {
+			t.Errorf("Run(%v) should not execute the command", args)
+		}
+	}
+}
+
+func Test_RunErrors(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr string
+	}{
+		{args: nil, wantErr: "Must specify a command"},
+		{args: []string{"Unknown"}, wantErr: "Unknown command Unknown"},
+		{args: []string{"Help", "Unknown"}, wantErr: "Unknown command Unknown"},
+		{args: []string{"DeleteDatabase", "--NotAFlag"}, wantErr: "Failed to get flags for command: DeleteDatabase"},
+		{args: []string{"DeleteDatabase", "--ConnectionString=postgres://db"}, wantErr: "must have a database name"},
+	}
+
+	for _, tt := range tests {
+		sut, _ := commandParserTestSetup()
+
+		if err := sut.Run(tt.args); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Run(%v) should fail with %s, got %v", tt.args, tt.wantErr, err)
+		}
+	}
+}
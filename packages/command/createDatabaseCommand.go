@@ -0,0 +1,184 @@
+package command
+
+import (
+	"context"
+	"crypto/rand"
+	"log"
+	"math/big"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
+	"github.com/pkg/errors"
+)
+
+const (
+	passwordLength   = 32
+	passwordAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+type CreateDatabaseCommand struct {
+	kubernetesManager external.IKubernetesManager
+	databaseManager   external.IDatabaseManager
+	fileReader        external.IFileReader
+	cmd               external.IFlagSet
+
+	Database             string
+	Template             *string
+	Owner                *string
+	CreateRole           *bool
+	SecretName           *string
+	SecretNamespace      *string
+	Kubeconfig           *string
+	Context              *string
+	KubeNamespace        *string
+	ConnectionString     *string
+	ConnectionStringFile *string
+	PushGateway          *string
+}
+
+func NewCreateDatabaseCommand(flagProvider external.IFlagProvider, kubernetesManager external.IKubernetesManager, databaseManager external.IDatabaseManager, fileReader external.IFileReader) *CreateDatabaseCommand {
+	return &CreateDatabaseCommand{
+		kubernetesManager: kubernetesManager,
+		databaseManager:   databaseManager,
+		fileReader:        fileReader,
+		cmd:               flagProvider.NewFlagSet("CreateDatabase", "Create the Database of a branch, named like CleanBranch, Usage: CreateDatabase <branch> [args]"),
+	}
+}
+
+func (d *CreateDatabaseCommand) Names() []string {
+	return []string{"CreateDatabase", "create-database"}
+}
+
+func (d *CreateDatabaseCommand) GetFlags(args []string) (err error) {
+	d.Template = d.cmd.String("Template", "", "Database to copy, such as a seeded database, it must not have other connections while it is copied")
+	d.Owner = d.cmd.String("Owner", "", "Role that owns the database, defaults to the connecting role")
+	d.CreateRole = d.cmd.Bool("CreateRole", false, "Create a login role named like the database that owns it, and write its credentials to a Secret")
+	d.SecretName = d.cmd.String("SecretName", "", "Name of the Secret for the role's credentials, defaults to <database>-postgres")
+	d.SecretNamespace = d.cmd.String("SecretNamespace", "", "Namespace of the Secret for the role's credentials, defaults to the namespace of the kubeconfig context")
+	d.Kubeconfig = d.cmd.String("Kubeconfig", "", "Path to kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config when not running in a cluster")
+	d.Context = d.cmd.String("Context", "", "Kubeconfig context to use instead of the current context")
+	d.KubeNamespace = d.cmd.String("KubeNamespace", "", "Override the namespace of the kubeconfig context")
+	d.ConnectionString = d.cmd.String("ConnectionString", "", "Database connectionString, postgres://, mysql:// or sqlserver://, prefer --ConnectionStringFile or "+external.ConnectionStringEnv+" to keep it out of the process list")
+	d.ConnectionStringFile = d.cmd.String("ConnectionStringFile", "", "Path to a file containing the database connectionString, such as a mounted Secret")
+	d.PushGateway = d.cmd.String("PushGateway", "", "Prometheus pushgateway url to push metrics to")
+
+	if err = d.cmd.Parse(args); err != nil {
+		return err
+	}
+
+	if d.cmd.Arg(0) == "" {
+		d.cmd.PrintDefaults()
+		return errors.New("CreateDatabase must have a branch name")
+	}
+	d.Database = CleanBranch(d.cmd.Arg(0))
+
+	d.ConnectionString, err = resolveConnectionString(d.fileReader, d.ConnectionString, d.ConnectionStringFile)
+	return
+}
+
+func (d *CreateDatabaseCommand) Execute() (err error) {
+	defer pushMetrics(d.PushGateway, "collie_createdatabase")
+
+	options := external.CreateDatabaseOptions{Template: *d.Template, Owner: *d.Owner}
+
+	if *d.CreateRole {
+		if options.Owner != "" {
+			return errors.New("--Owner can't be used with --CreateRole, the created role owns the database")
+		}
+
+		if err = connectCluster(d.kubernetesManager, clusterFlags(d.Kubeconfig, d.Context, d.KubeNamespace)); err != nil {
+			return err
+		}
+
+		options.Owner = d.Database
+	}
+
+	// Copying a large template can take a while, so there is no timeout.
+	ctx := context.Background()
+
+	if err = d.databaseManager.Connect(ctx, *d.ConnectionString); err != nil {
+		return errors.Wrap(err, "Execute failed to connect")
+	}
+	defer d.databaseManager.Close(ctx)
+
+	var password string
+	var roleCreated bool
+	if *d.CreateRole {
+		if password, err = generatePassword(); err != nil {
+			return err
+		}
+
+		if roleCreated, err = d.databaseManager.CreateRole(ctx, d.Database, password); err != nil {
+			return errors.Wrap(err, "Execute failed to create role")
+		}
+	}
+
+	created, err := d.databaseManager.CreateDatabase(ctx, d.Database, options)
+
+	if err != nil {
+		// A role created for the database would be left behind without it, a role from an earlier run is kept.
+		if roleCreated {
+			if dropErr := d.databaseManager.DropRole(ctx, d.Database); dropErr != nil {
+				log.Printf("Failed to drop role %s after the database wasn't created: %s", d.Database, dropErr)
+			}
+		}
+
+		return errors.Wrap(err, "Execute failed to create database")
+	}
+
+	if created {
+		metrics.DatabasesCreated.Inc()
+		log.Printf("Database %s created", d.Database)
+	} else {
+		log.Printf("Database %s already exists", d.Database)
+	}
+
+	if !*d.CreateRole {
+		return
+	}
+
+	return d.writeRoleSecret(password)
+}
+
+// writeRoleSecret writes the credentials of the database's role to the Secret.
+func (d *CreateDatabaseCommand) writeRoleSecret(password string) (err error) {
+	connectionString, err := external.RoleConnectionString(*d.ConnectionString, d.Database, password, d.Database)
+
+	if err != nil {
+		return err
+	}
+
+	secretName := *d.SecretName
+	if secretName == "" {
+		secretName = d.Database + "-postgres"
+	}
+
+	if err = d.kubernetesManager.ApplySecret(*d.SecretNamespace, secretName, map[string]string{
+		"database":                   d.Database,
+		"username":                   d.Database,
+		"password":                   password,
+		external.ConnectionStringKey: connectionString,
+	}); err != nil {
+		return errors.Wrap(err, "Execute failed to write role secret")
+	}
+
+	log.Printf("Credentials of role %s written to Secret %s", d.Database, secretName)
+	return
+}
+
+func generatePassword() (string, error) {
+	password := make([]byte, passwordLength)
+	max := big.NewInt(int64(len(passwordAlphabet)))
+
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+
+		if err != nil {
+			return "", errors.Wrap(err, "Failed to generate password")
+		}
+
+		password[i] = passwordAlphabet[n.Int64()]
+	}
+
+	return string(password), nil
+}
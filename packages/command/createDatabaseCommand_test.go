@@ -0,0 +1,134 @@
+package command_test
+
+import (
+	"errors"
+	"testing"
+
+	"bitbucket.org/centeva/collie/packages/command"
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/testutils"
+)
+
+func createDatabaseTestSetup(template string, owner string, createRole bool) (*command.CreateDatabaseCommand, *testutils.MockKubernetesManager, *testutils.MockDatabaseManager) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.CreateDatabaseRes = true
+	sut := command.NewCreateDatabaseCommand(testutils.NewMockFlagProvider(), mockKubernetesManager, mockDatabaseManager, testutils.NewMockFileReader(""))
+
+	connectionString := "postgres://admin:secret@db:5432/postgres"
+	empty := ""
+	sut.Database = "feature-1"
+	sut.Template = &template
+	sut.Owner = &owner
+	sut.CreateRole = &createRole
+	sut.ConnectionString = &connectionString
+	sut.SecretName = &empty
+	sut.SecretNamespace = &empty
+	sut.Kubeconfig = &empty
+	sut.Context = &empty
+	sut.KubeNamespace = &empty
+	sut.PushGateway = &empty
+
+	return sut, mockKubernetesManager, mockDatabaseManager
+}
+
+func Test_CreateDatabaseExecute(t *testing.T) {
+	sut, mockKubernetesManager, mockDatabaseManager := createDatabaseTestSetup("seed", "app", false)
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	args := mockDatabaseManager.CalledWith["createdatabase"][0].(*testutils.DMCreateDatabaseArgs)
+	want := external.CreateDatabaseOptions{Template: "seed", Owner: "app"}
+
+	if args.Database != "feature-1" || args.Options != want {
+		t.Errorf("CreateDatabase() should have been called with feature-1 %+v, got %+v", want, args)
+	}
+
+	if mockDatabaseManager.Called["createrole"] != 0 || mockKubernetesManager.Called["connect"] != 0 {
+		t.Errorf("Execute() should not create a role or connect to the cluster without --CreateRole")
+	}
+
+	if mockDatabaseManager.Called["close"] != 1 {
+		t.Errorf("Close() should have been called once")
+	}
+}
+
+func Test_CreateDatabaseExecuteCreateRole(t *testing.T) {
+	sut, mockKubernetesManager, mockDatabaseManager := createDatabaseTestSetup("", "", true)
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	role := mockDatabaseManager.CalledWith["createrole"][0].(*testutils.DMCreateRoleArgs)
+	if role.Role != "feature-1" || len(role.Password) != 32 {
+		t.Errorf("CreateRole() should create role feature-1 with a generated password, got %+v", role)
+	}
+
+	database := mockDatabaseManager.CalledWith["createdatabase"][0].(*testutils.DMCreateDatabaseArgs)
+	if database.Options.Owner != "feature-1" {
+		t.Errorf("CreateDatabase() should make the role the owner, got %+v", database.Options)
+	}
+
+	if mockKubernetesManager.Called["applysecret"] != 1 {
+		t.Fatalf("ApplySecret() should have been called once")
+	}
+
+	secret := mockKubernetesManager.CalledWith["applysecret"][0].(*testutils.KMApplySecretArgs)
+	if secret.Name != "feature-1-postgres" || secret.Data["password"] != role.Password || secret.Data["username"] != "feature-1" {
+		t.Errorf("ApplySecret() should write the role's credentials to feature-1-postgres, got %+v", secret)
+	}
+
+	if want := "postgres://feature-1:" + role.Password + "@db:5432/feature-1"; secret.Data[external.ConnectionStringKey] != want {
+		t.Errorf("ApplySecret() should write the connection string %s, got %s", want, secret.Data[external.ConnectionStringKey])
+	}
+}
+
+func Test_CreateDatabaseExecuteCreateDatabaseFails(t *testing.T) {
+	tests := []struct {
+		name        string
+		roleCreated bool
+		wantDrops   int
+	}{
+		{name: "drops the role it created", roleCreated: true, wantDrops: 1},
+		{name: "keeps a role from an earlier run", roleCreated: false, wantDrops: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, mockKubernetesManager, mockDatabaseManager := createDatabaseTestSetup("", "", true)
+			mockDatabaseManager.CreateRoleRes = tt.roleCreated
+			mockDatabaseManager.CreateDatabaseErr = errors.New("template is busy")
+
+			if err := sut.Execute(); err == nil {
+				t.Fatalf("Execute() should error when the database isn't created")
+			}
+
+			if mockDatabaseManager.Called["droprole"] != tt.wantDrops {
+				t.Errorf("DropRole() should have been called %d times, got %d", tt.wantDrops, mockDatabaseManager.Called["droprole"])
+			}
+
+			if tt.wantDrops == 1 && mockDatabaseManager.CalledWith["droprole"][0].(*testutils.DMDropRoleArgs).Role != "feature-1" {
+				t.Errorf("DropRole() should drop role feature-1, got %+v", mockDatabaseManager.CalledWith["droprole"][0])
+			}
+
+			if mockKubernetesManager.Called["applysecret"] != 0 {
+				t.Errorf("ApplySecret() should not be called when the database isn't created")
+			}
+		})
+	}
+}
+
+func Test_CreateDatabaseExecuteOwnerWithCreateRole(t *testing.T) {
+	sut, _, mockDatabaseManager := createDatabaseTestSetup("", "app", true)
+
+	if err := sut.Execute(); err == nil {
+		t.Errorf("Execute() should reject --Owner with --CreateRole")
+	}
+
+	if mockDatabaseManager.Called["connect"] != 0 {
+		t.Errorf("Execute() should not connect when the flags conflict")
+	}
+}
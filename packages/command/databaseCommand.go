@@ -1,58 +1,299 @@
 package command
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"path"
+	"regexp"
 	"strings"
+	"time"
 
 	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
 	"github.com/pkg/errors"
 )
 
 type DatabaseCommand struct {
-	postgresManager external.IPostgresManager
+	databaseManager external.IDatabaseManager
+	fileReader      external.IFileReader
 	cmd             external.IFlagSet
 
-	Database         string
-	ConnectionString *string
+	Databases            []string
+	ConnectionString     *string
+	ConnectionStringFile *string
+	Backup               *string
+	BackupEndpoint       *string
+	BackupRegion         *string
+	BackupInsecure       *bool
+	BackupRetention      *string
+	DropRole             *bool
+	Match                *bool
+	DryRun               *bool
+	Timeout              *string
+	BackupTimeout        *string
+	PushGateway          *string
 }
 
-func NewDatabaseCommand(flagProvider external.IFlagProvider, postgresManager external.IPostgresManager) *DatabaseCommand {
+func NewDatabaseCommand(flagProvider external.IFlagProvider, databaseManager external.IDatabaseManager, fileReader external.IFileReader) *DatabaseCommand {
 	return &DatabaseCommand{
-		postgresManager: postgresManager,
-		cmd:             flagProvider.NewFlagSet("DeleteDatabase", "Delete a Postgres Database Usage: DeleteDatabase <database> [args]"),
+		databaseManager: databaseManager,
+		fileReader:      fileReader,
+		cmd:             flagProvider.NewFlagSet("DeleteDatabase", "Delete Databases over one connection, Usage: DeleteDatabase <database|pattern>... [args]"),
 	}
 }
 
-func (d *DatabaseCommand) IsCurrent() bool {
-	return len(os.Args) > 1 && strings.EqualFold(os.Args[1], "DeleteDatabase")
+func (d *DatabaseCommand) Names() []string {
+	return []string{"DeleteDatabase", "delete-database"}
 }
 
-func (d *DatabaseCommand) GetFlags() (err error) {
-	d.ConnectionString = d.cmd.String("ConnectionString", "", "(required) Postgres database connectionString")
+func (d *DatabaseCommand) GetFlags(args []string) (err error) {
+	d.ConnectionString = d.cmd.String("ConnectionString", "", "Database connectionString, postgres://, mysql:// or sqlserver://, prefer --ConnectionStringFile or "+external.ConnectionStringEnv+" to keep it out of the process list")
+	d.ConnectionStringFile = d.cmd.String("ConnectionStringFile", "", "Path to a file containing the database connectionString, such as a mounted Secret")
+	d.Backup = d.cmd.String("Backup", "", "Back up the database before it is dropped to a local directory or s3://bucket/prefix, needs pg_dump")
+	d.BackupEndpoint = d.cmd.String("BackupEndpoint", "", "S3 compatible endpoint for an s3:// --Backup, credentials are read from the AWS_* or MINIO_* env variables")
+	d.BackupRegion = d.cmd.String("BackupRegion", "", "Region of the --Backup bucket")
+	d.BackupInsecure = d.cmd.Bool("BackupInsecure", false, "Use http for the --BackupEndpoint")
+	d.BackupRetention = d.cmd.String("BackupRetention", "", "Number of backups kept per database, older ones are removed, all are kept when not set")
+	d.DropRole = d.cmd.Bool("DropRole", false, "Drop the role named like the database after the database, reassigning what it owns elsewhere to the connecting role. Only a role created by CreateDatabase --CreateRole is dropped")
+	d.Match = d.cmd.Bool("Match", false, "Treat the names as patterns and delete every database matching one, a glob such as 'pr-*' or a regular expression between slashes such as '/^pr-[0-9]+$/'")
+	d.DryRun = d.cmd.Bool("DryRun", false, "Only log the databases that would be deleted")
+	d.Timeout = d.cmd.String("Timeout", DefaultTeardownTimeouts.Database.String(), "Timeout for connecting to and for dropping the database, each, also set as the session's lock_timeout and statement_timeout on Postgres, 0 waits forever")
+	d.BackupTimeout = d.cmd.String("BackupTimeout", DefaultTeardownTimeouts.Backup.String(), "Timeout for backing up the database when --Backup is set, 0 waits forever")
+	d.PushGateway = d.cmd.String("PushGateway", "", "Prometheus pushgateway url to push metrics to")
 
-	if len(os.Args) <= 2 || os.Args[2] == "" {
+	if err = d.cmd.Parse(args); err != nil {
+		return err
+	}
+
+	for _, database := range d.cmd.Args() {
+		if database != "" {
+			d.Databases = append(d.Databases, database)
+		}
+	}
+
+	if len(d.Databases) == 0 {
 		return errors.New("DeleteDatabase must have a database name")
 	}
-	d.Database = os.Args[2]
 
-	d.cmd.Parse(os.Args[3:])
+	return d.ResolveConnectionString()
+}
+
+// ResolveConnectionString uses --ConnectionString, then --ConnectionStringFile, then the COLLIE_CONNECTION_STRING env variable.
+func (d *DatabaseCommand) ResolveConnectionString() (err error) {
+	d.ConnectionString, err = resolveConnectionString(d.fileReader, d.ConnectionString, d.ConnectionStringFile)
 	return
 }
 
+func resolveConnectionString(fileReader external.IFileReader, connectionString *string, connectionStringFile *string) (*string, error) {
+	if connectionString != nil && *connectionString != "" {
+		return connectionString, nil
+	}
+
+	resolved := ""
+
+	if connectionStringFile != nil && *connectionStringFile != "" {
+		file, err := fileReader.ReadFile(*connectionStringFile)
+
+		if err != nil {
+			return connectionString, errors.Wrap(err, "Failed to read ConnectionStringFile")
+		}
+
+		resolved = strings.TrimSpace(string(file))
+	} else {
+		resolved = os.Getenv(external.ConnectionStringEnv)
+	}
+
+	if resolved == "" {
+		return connectionString, errors.Errorf("ConnectionString is required, set --ConnectionString, --ConnectionStringFile or %s", external.ConnectionStringEnv)
+	}
+
+	return &resolved, nil
+}
+
+// connectDatabase connects within the timeout, which the PostgresManager also sets as the session's lock_timeout and
+// statement_timeout.
+func connectDatabase(databaseManager external.IDatabaseManager, connectionString string, timeout time.Duration) error {
+	ctx, cancel := contextWithTimeout(timeout)
+	defer cancel()
+
+	return databaseManager.Connect(ctx, connectionString)
+}
+
+// reconnectDatabase reconnects when ctx ran out. pgx closes the connection when a statement is cancelled, so the
+// databases after one that timed out would otherwise all fail with conn closed.
+func reconnectDatabase(ctx context.Context, databaseManager external.IDatabaseManager, connectionString string, timeout time.Duration) {
+	if ctx.Err() == nil {
+		return
+	}
+
+	databaseManager.Close(context.Background())
+
+	if err := connectDatabase(databaseManager, connectionString, timeout); err != nil {
+		log.Printf("Failed to reconnect to the database: %s", err)
+	}
+}
+
 func (d *DatabaseCommand) Execute() (err error) {
-	err = d.postgresManager.Connect(*d.ConnectionString)
+	defer pushMetrics(d.PushGateway, "collie_deletedatabase")
 
+	backup, err := backupFlags(d.Backup, d.BackupEndpoint, d.BackupRegion, d.BackupInsecure, d.BackupRetention)
 	if err != nil {
-		return errors.Wrap(err, "Execute failed to connect")
+		return err
+	}
+
+	timeout, err := parseTimeout(d.Timeout, "Timeout", DefaultTeardownTimeouts.Database)
+	if err != nil {
+		return err
 	}
-	defer d.postgresManager.Close()
-	err = d.postgresManager.DeleteDatabase(d.Database)
 
+	backupTimeout, err := parseTimeout(d.BackupTimeout, "BackupTimeout", DefaultTeardownTimeouts.Backup)
 	if err != nil {
-		return errors.Wrap(err, "Execute failed to delete database")
+		return err
+	}
+
+	match := d.Match != nil && *d.Match
+	if match {
+		// Fail on a bad pattern before connecting.
+		if _, err = matchDatabases(d.Databases, nil); err != nil {
+			return err
+		}
+	}
+
+	if err = connectDatabase(d.databaseManager, *d.ConnectionString, timeout); err != nil {
+		return errors.Wrap(err, "Execute failed to connect")
+	}
+	defer d.databaseManager.Close(context.Background())
+
+	databases := d.Databases
+	if match {
+		ctx, cancel := contextWithTimeout(timeout)
+		existing, err := d.databaseManager.ListDatabases(ctx)
+		cancel()
+
+		if err != nil {
+			return errors.Wrap(err, "Execute failed to list databases")
+		}
+
+		if databases, err = matchDatabases(d.Databases, existing); err != nil {
+			return err
+		}
+
+		if len(databases) == 0 {
+			log.Printf("No databases match %s", strings.Join(d.Databases, " "))
+			return nil
+		}
+	}
+
+	if d.DryRun != nil && *d.DryRun {
+		log.Printf("Would delete databases %s", databases)
+		return
+	}
+
+	var allErrs []string
+	var deleted, missing int
+	for _, database := range databases {
+		dropped, err := d.deleteDatabase(database, backup, timeout, backupTimeout)
+
+		switch {
+		case err != nil:
+			log.Printf("Database %s failed: %s", database, err)
+			allErrs = append(allErrs, fmt.Sprintf(" %d) %s: %s", len(allErrs), database, err))
+		case dropped:
+			deleted++
+		default:
+			missing++
+		}
+	}
+
+	log.Printf("Deleted %d of %d databases, %d did not exist", deleted, len(databases), missing)
+
+	if len(allErrs) > 0 {
+		allErr := errors.New(strings.Join(allErrs, ""))
+		return errors.Wrapf(allErr, "Execute failed to delete %d of %d databases", len(allErrs), len(databases))
+	}
+
+	return
+}
+
+// deleteDatabase backs up the database when backup is set, drops it, then drops its role when --DropRole is set.
+// dropped is false when the database didn't exist.
+func (d *DatabaseCommand) deleteDatabase(database string, backup *external.BackupConfig, timeout time.Duration, backupTimeout time.Duration) (dropped bool, err error) {
+	if backup != nil {
+		ctx, cancel := contextWithTimeout(backupTimeout)
+		defer cancel()
+
+		if err = backupDatabase(ctx, d.databaseManager, *backup, database); err != nil {
+			reconnectDatabase(ctx, d.databaseManager, *d.ConnectionString, timeout)
+			return false, errors.Wrap(err, "failed to back up database, it was not deleted")
+		}
+	}
+
+	ctx, cancel := contextWithTimeout(timeout)
+	defer cancel()
+
+	if dropped, err = d.databaseManager.DeleteDatabase(ctx, database); err != nil {
+		reconnectDatabase(ctx, d.databaseManager, *d.ConnectionString, timeout)
+		return false, errors.Wrap(err, "failed to delete database")
+	}
+
+	if dropped {
+		metrics.DatabasesDropped.Inc()
+		log.Printf("Database %s deleted", database)
+	} else {
+		log.Printf("Database %s does not exist", database)
+	}
+
+	if d.DropRole == nil || !*d.DropRole {
+		return dropped, nil
+	}
+
+	if err = d.databaseManager.DropRole(ctx, database); err != nil {
+		reconnectDatabase(ctx, d.databaseManager, *d.ConnectionString, timeout)
+		return false, errors.Wrap(err, "failed to drop role")
+	}
+
+	log.Printf("Role %s dropped", database)
+	return dropped, nil
+}
+
+// matchDatabases returns the databases matching any of the patterns, in order. A pattern is a glob such as pr-*,
+// or a regular expression between slashes such as /^pr-[0-9]+$/.
+func matchDatabases(patterns []string, databases []string) (matches []string, err error) {
+	var matchers []func(database string) bool
+
+	for _, pattern := range patterns {
+		pattern := pattern
+
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expression, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid pattern %s", pattern)
+			}
+
+			matchers = append(matchers, expression.MatchString)
+			continue
+		}
+
+		if _, err = path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "Invalid pattern %s", pattern)
+		}
+
+		matchers = append(matchers, func(database string) bool {
+			matched, _ := path.Match(pattern, database)
+			return matched
+		})
+	}
+
+	for _, database := range databases {
+		for _, match := range matchers {
+			if match(database) {
+				matches = append(matches, database)
+				break
+			}
+		}
 	}
 
-	log.Printf("Database %s deleted", d.Database)
 	return
 }
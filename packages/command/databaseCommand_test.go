@@ -1,27 +1,37 @@
 package command_test
 
 import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"bitbucket.org/centeva/collie/packages/command"
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
 	"bitbucket.org/centeva/collie/testutils"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func Test_executeConnect(t *testing.T) {
-	mockPostgresManager := testutils.NewMockPostgresManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewDatabaseCommand(mockFlagProvider, mockPostgresManager)
+	sut := command.NewDatabaseCommand(mockFlagProvider, mockDatabaseManager, testutils.NewMockFileReader(""))
 	connectionString := "testConnString"
 	sut.ConnectionString = &connectionString
 	sut.Execute()
 
-	if mockPostgresManager.Called["connect"] != 1 {
+	if mockDatabaseManager.Called["connect"] != 1 {
 		t.Errorf("Connect() should have been called once")
 	}
 
-	firstArg := mockPostgresManager.CalledWith["connect"][0]
+	firstArg := mockDatabaseManager.CalledWith["connect"][0]
 	switch arg := firstArg.(type) {
-	case *testutils.PMConnectArgs:
+	case *testutils.DMConnectArgs:
 		flat := *arg
 		if flat.ConnectionString == connectionString {
 			return
@@ -32,23 +42,23 @@ func Test_executeConnect(t *testing.T) {
 }
 
 func Test_executeDeleteDatabase(t *testing.T) {
-	mockPostgresManager := testutils.NewMockPostgresManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewDatabaseCommand(mockFlagProvider, mockPostgresManager)
+	sut := command.NewDatabaseCommand(mockFlagProvider, mockDatabaseManager, testutils.NewMockFileReader(""))
 	connectionString := "testConnString"
 	sut.ConnectionString = &connectionString
-	sut.Database = "testDB"
+	sut.Databases = []string{"testDB"}
 	sut.Execute()
 
-	if mockPostgresManager.Called["deletedatabase"] != 1 {
+	if mockDatabaseManager.Called["deletedatabase"] != 1 {
 		t.Errorf("DeleteDatabase() should have been called once")
 	}
 
-	firstArg := mockPostgresManager.CalledWith["deletedatabase"][0]
+	firstArg := mockDatabaseManager.CalledWith["deletedatabase"][0]
 	switch arg := firstArg.(type) {
-	case *testutils.PMDeleteDatabaseArgs:
+	case *testutils.DMDeleteDatabaseArgs:
 		flat := *arg
-		if flat.Database == sut.Database {
+		if flat.Database == sut.Databases[0] {
 			return
 		}
 	}
@@ -57,14 +67,318 @@ func Test_executeDeleteDatabase(t *testing.T) {
 }
 
 func Test_executeClose(t *testing.T) {
-	mockPostgresManager := testutils.NewMockPostgresManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
-	sut := command.NewDatabaseCommand(mockFlagProvider, mockPostgresManager)
+	sut := command.NewDatabaseCommand(mockFlagProvider, mockDatabaseManager, testutils.NewMockFileReader(""))
 	connectionString := "testConnString"
 	sut.ConnectionString = &connectionString
 	sut.Execute()
 
-	if mockPostgresManager.Called["close"] != 1 {
+	if mockDatabaseManager.Called["close"] != 1 {
 		t.Errorf("Connect() should have been called once")
 	}
 }
+
+func Test_executeTimeout(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      string
+		wantDeadline bool
+		wantErr      bool
+	}{
+		{name: "default", timeout: "", wantDeadline: true},
+		{name: "set", timeout: "30s", wantDeadline: true},
+		{name: "zero waits forever", timeout: "0"},
+		{name: "invalid", timeout: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDatabaseManager := testutils.NewMockDatabaseManager()
+			sut := command.NewDatabaseCommand(testutils.NewMockFlagProvider(), mockDatabaseManager, testutils.NewMockFileReader(""))
+			connectionString := "testConnString"
+			sut.ConnectionString = &connectionString
+			sut.Timeout = &tt.timeout
+			sut.Databases = []string{"testDB"}
+
+			err := sut.Execute()
+
+			if tt.wantErr {
+				if err == nil || mockDatabaseManager.Called["connect"] != 0 {
+					t.Errorf("Execute() should fail before connecting, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Execute() should not error, got %s", err)
+			}
+
+			connect := mockDatabaseManager.CalledWith["connect"][0].(*testutils.DMConnectArgs)
+			deleteDatabase := mockDatabaseManager.CalledWith["deletedatabase"][0].(*testutils.DMDeleteDatabaseArgs)
+
+			if connect.HasDeadline != tt.wantDeadline || deleteDatabase.HasDeadline != tt.wantDeadline {
+				t.Errorf("Connect() and DeleteDatabase() should have a deadline: %t, got %+v %+v", tt.wantDeadline, connect, deleteDatabase)
+			}
+		})
+	}
+}
+
+func Test_executeDropRole(t *testing.T) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	sut := command.NewDatabaseCommand(testutils.NewMockFlagProvider(), mockDatabaseManager, testutils.NewMockFileReader(""))
+	connectionString := "testConnString"
+	dropRole := true
+	sut.ConnectionString = &connectionString
+	sut.DropRole = &dropRole
+	sut.Databases = []string{"testDB"}
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if mockDatabaseManager.Called["droprole"] != 1 {
+		t.Fatalf("DropRole() should have been called once, got %v", mockDatabaseManager.Called)
+	}
+
+	if args := mockDatabaseManager.CalledWith["droprole"][0].(*testutils.DMDropRoleArgs); args.Role != "testDB" {
+		t.Errorf("DropRole() should have been called with testDB, got %+v", args)
+	}
+}
+
+func Test_executeBackupRetention(t *testing.T) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.ListDatabasesRes = []string{"testDB"}
+	sut := command.NewDatabaseCommand(testutils.NewMockFlagProvider(), mockDatabaseManager, testutils.NewMockFileReader(""))
+	connectionString := "testConnString"
+	backup := t.TempDir()
+	retention := "2"
+	sut.ConnectionString = &connectionString
+	sut.Databases = []string{"testDB"}
+	sut.Backup = &backup
+	sut.BackupRetention = &retention
+
+	os.MkdirAll(filepath.Join(backup, "testDB"), 0o700)
+	for _, old := range []string{"testDB-20200101T000000Z.dump", "testDB-20200102T000000Z.dump", "other.txt"} {
+		os.WriteFile(filepath.Join(backup, "testDB", old), []byte("old"), 0o600)
+	}
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(backup, "testDB", "testDB-*.dump"))
+	if len(backups) != 2 || filepath.Base(backups[0]) != "testDB-20200102T000000Z.dump" {
+		t.Errorf("Execute() should keep the newest 2 backups, got %v", backups)
+	}
+
+	if _, err := os.Stat(filepath.Join(backup, "testDB", "other.txt")); err != nil {
+		t.Errorf("Execute() should only remove backups, got %s", err)
+	}
+
+	if mockDatabaseManager.Called["deletedatabase"] != 1 {
+		t.Errorf("DeleteDatabase() should have been called once after the backup")
+	}
+}
+
+func Test_executeBackupMissingDatabase(t *testing.T) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	sut := command.NewDatabaseCommand(testutils.NewMockFlagProvider(), mockDatabaseManager, testutils.NewMockFileReader(""))
+	connectionString := "testConnString"
+	backup := t.TempDir()
+	sut.ConnectionString = &connectionString
+	sut.Databases = []string{"testDB"}
+	sut.Backup = &backup
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if mockDatabaseManager.Called["backupdatabase"] != 0 {
+		t.Errorf("BackupDatabase() should not be called for a missing database")
+	}
+}
+
+func batchDeleteTestSetup(mockDatabaseManager *testutils.MockDatabaseManager, match bool, databases ...string) *command.DatabaseCommand {
+	sut := command.NewDatabaseCommand(testutils.NewMockFlagProvider(), mockDatabaseManager, testutils.NewMockFileReader(""))
+	connectionString := "testConnString"
+	dryRun := false
+	sut.ConnectionString = &connectionString
+	sut.Match = &match
+	sut.DryRun = &dryRun
+	sut.Databases = databases
+	return sut
+}
+
+func deletedDatabases(mockDatabaseManager *testutils.MockDatabaseManager) (deleted []string) {
+	for _, arg := range mockDatabaseManager.CalledWith["deletedatabase"] {
+		deleted = append(deleted, arg.(*testutils.DMDeleteDatabaseArgs).Database)
+	}
+	return
+}
+
+func Test_executeBatchDelete(t *testing.T) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.DeleteDatabaseErrs = map[string]error{"pr-2": errors.New("database is being accessed by other users")}
+	sut := batchDeleteTestSetup(mockDatabaseManager, false, "pr-1", "pr-2", "pr-3")
+
+	err := sut.Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "failed to delete 1 of 3 databases") || !strings.Contains(err.Error(), "pr-2") {
+		t.Errorf("Execute() should report the failed database, got %v", err)
+	}
+
+	if mockDatabaseManager.Called["connect"] != 1 || mockDatabaseManager.Called["close"] != 1 {
+		t.Errorf("Execute() should use a single connection, got %v", mockDatabaseManager.Called)
+	}
+
+	if deleted := deletedDatabases(mockDatabaseManager); !reflect.DeepEqual(deleted, []string{"pr-1", "pr-2", "pr-3"}) {
+		t.Errorf("Execute() should keep deleting after a failure, got %v", deleted)
+	}
+}
+
+func Test_executeBatchDeleteMissingDatabase(t *testing.T) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.DeleteDatabaseMissing = map[string]bool{"pr-2": true}
+	sut := batchDeleteTestSetup(mockDatabaseManager, false, "pr-1", "pr-2")
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	dropped := testutil.ToFloat64(metrics.DatabasesDropped)
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error for a missing database, got %s", err)
+	}
+
+	if count := testutil.ToFloat64(metrics.DatabasesDropped) - dropped; count != 1 {
+		t.Errorf("Execute() should count only the dropped database, got %v", count)
+	}
+
+	for _, want := range []string{"Database pr-1 deleted", "Database pr-2 does not exist", "Deleted 1 of 2 databases, 1 did not exist"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Execute() should log %q, got %s", want, logs.String())
+		}
+	}
+
+	if strings.Contains(logs.String(), "Database pr-2 deleted") {
+		t.Errorf("Execute() should not log the missing database as deleted, got %s", logs.String())
+	}
+}
+
+func Test_executeBatchDeleteReconnectsAfterTimeout(t *testing.T) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.DeleteDatabaseTimesOut = map[string]bool{"pr-1": true}
+	sut := batchDeleteTestSetup(mockDatabaseManager, false, "pr-1", "pr-2")
+	timeout := "10ms"
+	sut.Timeout = &timeout
+
+	err := sut.Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "failed to delete 1 of 2 databases") {
+		t.Errorf("Execute() should report the database that timed out, got %v", err)
+	}
+
+	if mockDatabaseManager.Called["connect"] != 2 || mockDatabaseManager.Called["close"] != 2 {
+		t.Errorf("Execute() should reconnect after a timeout, got %v", mockDatabaseManager.Called)
+	}
+
+	if deleted := deletedDatabases(mockDatabaseManager); !reflect.DeepEqual(deleted, []string{"pr-1", "pr-2"}) {
+		t.Errorf("Execute() should keep deleting after a timeout, got %v", deleted)
+	}
+}
+
+func Test_executeMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{name: "glob", patterns: []string{"pr-*"}, want: []string{"pr-1", "pr-12"}},
+		{name: "regex", patterns: []string{"/^pr-[0-9]$/"}, want: []string{"pr-1"}},
+		{name: "several patterns", patterns: []string{"pr-1?", "feature-?"}, want: []string{"feature-a", "pr-12"}},
+		{name: "no match", patterns: []string{"qa-*"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDatabaseManager := testutils.NewMockDatabaseManager()
+			mockDatabaseManager.ListDatabasesRes = []string{"feature-a", "postgres", "pr-1", "pr-12"}
+			sut := batchDeleteTestSetup(mockDatabaseManager, true, tt.patterns...)
+
+			if err := sut.Execute(); err != nil {
+				t.Fatalf("Execute() should not error, got %s", err)
+			}
+
+			if deleted := deletedDatabases(mockDatabaseManager); !reflect.DeepEqual(deleted, tt.want) {
+				t.Errorf("Execute() should delete %v, got %v", tt.want, deleted)
+			}
+		})
+	}
+}
+
+func Test_executeMatchDryRun(t *testing.T) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.ListDatabasesRes = []string{"pr-1", "pr-2"}
+	sut := batchDeleteTestSetup(mockDatabaseManager, true, "pr-*")
+	dryRun := true
+	sut.DryRun = &dryRun
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if mockDatabaseManager.Called["deletedatabase"] != 0 {
+		t.Errorf("Execute() should not delete databases on a dry run")
+	}
+}
+
+func Test_executeMatchInvalidPattern(t *testing.T) {
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	sut := batchDeleteTestSetup(mockDatabaseManager, true, "/pr-(/")
+
+	if err := sut.Execute(); err == nil || mockDatabaseManager.Called["connect"] != 0 {
+		t.Errorf("Execute() should fail on an invalid pattern before connecting, got %v", err)
+	}
+}
+
+func Test_resolveConnectionString(t *testing.T) {
+	empty := ""
+	flag := "flagConnString"
+	file := "/secrets/connectionString"
+
+	tests := []struct {
+		name                 string
+		connectionString     *string
+		connectionStringFile *string
+		env                  string
+		want                 string
+		wantErr              bool
+	}{
+		{name: "should use flag", connectionString: &flag, connectionStringFile: &file, env: "envConnString", want: flag},
+		{name: "should use file", connectionString: &empty, connectionStringFile: &file, env: "envConnString", want: "fileConnString"},
+		{name: "should use env", connectionString: &empty, connectionStringFile: &empty, env: "envConnString", want: "envConnString"},
+		{name: "should error when missing", connectionString: &empty, connectionStringFile: &empty, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(external.ConnectionStringEnv, tt.env)
+			defer os.Unsetenv(external.ConnectionStringEnv)
+			mockFileReader := testutils.NewMockFileReader("")
+			mockFileReader.Files[file] = []byte("fileConnString\n")
+			sut := command.NewDatabaseCommand(testutils.NewMockFlagProvider(), testutils.NewMockDatabaseManager(), mockFileReader)
+			sut.ConnectionString = tt.connectionString
+			sut.ConnectionStringFile = tt.connectionStringFile
+
+			err := sut.ResolveConnectionString()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveConnectionString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && *sut.ConnectionString != tt.want {
+				t.Errorf("ResolveConnectionString() = %s, want %s", *sut.ConnectionString, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package command
+
+import (
+	"log"
+	"net/http"
+
+	"bitbucket.org/centeva/collie/packages/metrics"
+)
+
+// registerHealthHandlers adds liveness (/healthz), readiness (/readyz) and prometheus (/metrics) endpoints to the mux.
+func registerHealthHandlers(mux *http.ServeMux, ready func() bool) {
+	mux.Handle("/metrics", metrics.Handler())
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+}
+
+// pushMetrics sends metrics to the pushgateway when one is configured, one-shot commands exit before they can be scraped.
+func pushMetrics(pushGateway *string, job string) {
+	if pushGateway == nil || *pushGateway == "" {
+		return
+	}
+
+	if err := metrics.Push(*pushGateway, job); err != nil {
+		log.Printf("%s", err)
+	}
+}
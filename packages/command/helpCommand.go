@@ -2,8 +2,6 @@ package command
 
 import (
 	"log"
-	"os"
-	"strings"
 
 	"bitbucket.org/centeva/collie/packages/external"
 )
@@ -20,12 +18,12 @@ func NewHelpCommand(flagProvider external.IFlagProvider) *HelpCommand {
 	}
 }
 
-func (h *HelpCommand) IsCurrent() bool {
-	return len(os.Args) > 1 && strings.EqualFold(os.Args[1], "Help")
+func (h *HelpCommand) Names() []string {
+	return []string{"Help", "-h", "-help", "--help"}
 }
 
-func (h *HelpCommand) GetFlags() (err error) {
-	return
+func (h *HelpCommand) GetFlags(args []string) (err error) {
+	return h.cmd.Parse(args)
 }
 
 func (h *HelpCommand) Execute() (err error) {
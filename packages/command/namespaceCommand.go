@@ -3,11 +3,11 @@ package command
 import (
 	"context"
 	"log"
-	"os"
 	"strings"
 	"time"
 
 	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
 	"github.com/pkg/errors"
 )
 
@@ -18,7 +18,13 @@ type NamespaceCommand struct {
 	cmd               external.IFlagSet
 	Namespace         string
 	Kubeconfig        *string
+	Context           *string
+	KubeNamespace     *string
 	Timeout           *string
+	Wait              *bool
+	WaitTimeout       *string
+	ForceFinalizers   *bool
+	PushGateway       *string
 }
 
 func NewNamespaceCommand(flagProvider external.IFlagProvider, kubernetesManager external.IKubernetesManager) *NamespaceCommand {
@@ -28,21 +34,29 @@ func NewNamespaceCommand(flagProvider external.IFlagProvider, kubernetesManager
 	}
 }
 
-func (k *NamespaceCommand) IsCurrent() bool {
-	return len(os.Args) > 1 && strings.EqualFold(os.Args[1], "DeleteNamespace")
+func (k *NamespaceCommand) Names() []string {
+	return []string{"DeleteNamespace", "delete-namespace"}
 }
 
-func (k *NamespaceCommand) GetFlags() (err error) {
+func (k *NamespaceCommand) GetFlags(args []string) (err error) {
 	k.Timeout = k.cmd.String("Timeout", "10m", "Context Timout")
-	k.Kubeconfig = k.cmd.String("Kubeconfig", "", "Path to kubeconfig context file, used for running outside of the cluster")
+	k.Kubeconfig = k.cmd.String("Kubeconfig", "", "Path to kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config when not running in a cluster")
+	k.Context = k.cmd.String("Context", "", "Kubeconfig context to use instead of the current context")
+	k.KubeNamespace = k.cmd.String("KubeNamespace", "", "Override the namespace of the kubeconfig context")
+	k.Wait = k.cmd.Bool("Wait", false, "Wait until the namespace is gone, reports the resources and finalizers blocking it after --WaitTimeout")
+	k.WaitTimeout = k.cmd.String("WaitTimeout", "5m", "How long --Wait waits for the namespace to be gone, keep it below --Timeout")
+	k.ForceFinalizers = k.cmd.Bool("ForceFinalizers", false, "With --Wait, remove the finalizers of the resources left after --WaitTimeout and wait once more")
+	k.PushGateway = k.cmd.String("PushGateway", "", "Prometheus pushgateway url to push metrics to")
+
+	if err = k.cmd.Parse(args); err != nil {
+		return err
+	}
 
-	if len(os.Args) <= 2 || os.Args[2] == "" {
+	if k.cmd.Arg(0) == "" {
 		k.cmd.PrintDefaults()
 		return errors.New("Missing namespace, see usage.")
 	}
-	k.Namespace = os.Args[2]
-
-	k.cmd.Parse(os.Args[3:])
+	k.Namespace = k.cmd.Arg(0)
 	return
 }
 
@@ -60,22 +74,17 @@ func (k *NamespaceCommand) CreateContext() (ctx context.Context, err error) {
 }
 
 func (k *NamespaceCommand) Execute() (err error) {
+	defer pushMetrics(k.PushGateway, "collie_deletenamespace")
+
 	if _, err := k.CreateContext(); err != nil {
 		return errors.Wrap(err, "Failed to create context")
 	}
 
-	if k.Kubeconfig != nil && *k.Kubeconfig != "" {
-		if _, err := k.kubernetesManager.OutClusterConfig(k.ctx, *k.Kubeconfig); err != nil {
-			return errors.Wrap(err, "Failed to create outCluster config")
-		}
-	} else {
-		if _, err := k.kubernetesManager.InClusterConfig(k.ctx); err != nil {
-			return errors.Wrap(err, "Failed to create inCluster config, are you running in a cluster?")
-		}
-
+	if err := k.kubernetesManager.Connect(k.ctx, clusterFlags(k.Kubeconfig, k.Context, k.KubeNamespace)); err != nil {
+		return errors.Wrap(err, "Failed to connect to cluster")
 	}
 
-	list, err := k.kubernetesManager.GetNamespaces("")
+	list, err := k.kubernetesManager.GetNamespaces(k.ctx, "")
 	match := false
 	for _, v := range list {
 		match = match || v == k.Namespace
@@ -90,11 +99,100 @@ func (k *NamespaceCommand) Execute() (err error) {
 		return errors.Wrapf(err, "Failed to get namespaces")
 	}
 
-	if err := k.kubernetesManager.DeleteNamespace(k.Namespace); err != nil {
+	if err := k.kubernetesManager.DeleteNamespace(k.ctx, k.Namespace); err != nil {
 		return errors.Wrapf(err, "Failed to delete namespace %s", k.Namespace)
 	}
 
+	metrics.NamespacesDeleted.Inc()
+
+	if k.Wait == nil || !*k.Wait {
+		log.Printf("Namespace %s is terminating", k.Namespace)
+		return
+	}
+
+	waitTimeout, err := time.ParseDuration(*k.WaitTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to parse WaitTimeout: %s", *k.WaitTimeout)
+	}
+
+	if err = waitForNamespaceDeleted(k.ctx, k.kubernetesManager, k.Namespace, waitTimeout, k.ForceFinalizers != nil && *k.ForceFinalizers); err != nil {
+		return err
+	}
+
 	log.Printf("Deleted namespace %s", k.Namespace)
+	return
+}
+
+func clusterFlags(kubeconfig *string, kubeContext *string, namespace *string) (cluster external.ClusterConfig) {
+	if kubeconfig != nil {
+		cluster.Kubeconfig = *kubeconfig
+	}
+
+	if kubeContext != nil {
+		cluster.Context = *kubeContext
+	}
+
+	if namespace != nil {
+		cluster.Namespace = *namespace
+	}
+
+	return
+}
+
+// waitForNamespaceDeleted waits until the namespace is gone and reports what blocks it when it is not. With
+// forceFinalizers the finalizers of the remaining resources are removed and it waits once more. It stops once ctx is done.
+func waitForNamespaceDeleted(ctx context.Context, kubernetesManager external.IKubernetesManager, namespace string, timeout time.Duration, forceFinalizers bool) (err error) {
+	err = kubernetesManager.WaitForNamespaceDeleted(ctx, namespace, timeout)
+	if errors.Cause(err) != external.ErrNamespaceDeleteTimeout {
+		return err
+	}
+
+	status, err := reportNamespaceStatus(ctx, kubernetesManager, namespace)
+	if err != nil || status == nil {
+		return err
+	}
+
+	if !forceFinalizers {
+		return errors.Errorf("Namespace %s is still %s after %s", namespace, status.Phase, timeout)
+	}
+
+	log.Printf("Removing finalizers from the resources left in namespace %s", namespace)
+
+	if err = kubernetesManager.RemoveFinalizers(ctx, status.Resources); err != nil {
+		return err
+	}
+
+	if err = kubernetesManager.WaitForNamespaceDeleted(ctx, namespace, timeout); errors.Cause(err) == external.ErrNamespaceDeleteTimeout {
+		reportNamespaceStatus(ctx, kubernetesManager, namespace)
+	}
+
+	return err
+}
+
+// reportNamespaceStatus logs the finalizers, conditions and resources keeping the namespace around.
+func reportNamespaceStatus(ctx context.Context, kubernetesManager external.IKubernetesManager, namespace string) (status *external.NamespaceStatus, err error) {
+	status, err = kubernetesManager.GetNamespaceStatus(ctx, namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get status of namespace %s", namespace)
+	}
+
+	if status == nil {
+		return
+	}
+
+	log.Printf("Namespace %s is still %s", namespace, status.Phase)
+
+	if len(status.Finalizers) > 0 {
+		log.Printf("  finalizers: %s", strings.Join(status.Finalizers, ", "))
+	}
+
+	for _, condition := range status.Conditions {
+		log.Printf("  condition %s", condition)
+	}
+
+	for _, resource := range status.Resources {
+		log.Printf("  remaining %s", resource)
+	}
 
 	return
 }
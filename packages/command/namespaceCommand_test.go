@@ -5,39 +5,39 @@ import (
 	"testing"
 
 	"bitbucket.org/centeva/collie/packages/command"
+	"bitbucket.org/centeva/collie/packages/external"
 	"bitbucket.org/centeva/collie/testutils"
+	"github.com/pkg/errors"
 )
 
-func Test_executeOutCluster(t *testing.T) {
+func Test_executeNamespaceConnect(t *testing.T) {
 	mockKubernetesManager := testutils.NewMockKubernetesManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
 	sut := command.NewNamespaceCommand(mockFlagProvider, mockKubernetesManager)
 
 	log.Printf("setup")
 	kubeconfig := "/test/path.conf"
+	kubeContext := "testContext"
+	kubeNamespace := "testNamespace"
 	sut.Kubeconfig = &kubeconfig
+	sut.Context = &kubeContext
+	sut.KubeNamespace = &kubeNamespace
 	timeout := "10m"
 	sut.Timeout = &timeout
 	sut.Execute()
 
 	log.Printf("executed")
-	if mockKubernetesManager.Called["outclusterconfig"] != 1 {
-		t.Errorf("OutClusterConfig() should have been called once")
+	if mockKubernetesManager.Called["connect"] != 1 {
+		t.Fatalf("Connect() should have been called once")
 	}
 
-	firstArg := mockKubernetesManager.CalledWith["outclusterconfig"][0]
-	switch arg := firstArg.(type) {
-	case *testutils.KMOutClusterConfigArgs:
-		flat := *arg
-		if flat.Kubeconfig == "/test/path.conf" {
-			return
-		}
+	want := external.ClusterConfig{Kubeconfig: kubeconfig, Context: kubeContext, Namespace: kubeNamespace}
+	if args := mockKubernetesManager.CalledWith["connect"][0].(*testutils.KMConnectArgs); args.Cluster != want {
+		t.Errorf("Connect() should have been called with %+v but got %+v", want, args.Cluster)
 	}
-
-	t.Errorf("OutClusterConfig() should have been called with Kueconfig: %s but got %+v", kubeconfig, firstArg)
 }
 
-func Test_executeInCluster(t *testing.T) {
+func Test_executeNamespaceConnectDefaults(t *testing.T) {
 	mockKubernetesManager := testutils.NewMockKubernetesManager()
 	mockFlagProvider := testutils.NewMockFlagProvider()
 	sut := command.NewNamespaceCommand(mockFlagProvider, mockKubernetesManager)
@@ -46,8 +46,12 @@ func Test_executeInCluster(t *testing.T) {
 	sut.Timeout = &timeout
 	sut.Execute()
 
-	if mockKubernetesManager.Called["inclusterconfig"] != 1 {
-		t.Errorf("InClusterConfig() should have been called once")
+	if mockKubernetesManager.Called["connect"] != 1 {
+		t.Fatalf("Connect() should have been called once")
+	}
+
+	if args := mockKubernetesManager.CalledWith["connect"][0].(*testutils.KMConnectArgs); args.Cluster != (external.ClusterConfig{}) {
+		t.Errorf("Connect() should have been called with an empty ClusterConfig to use in cluster or default kubeconfig, got %+v", args.Cluster)
 	}
 }
 
@@ -102,3 +106,90 @@ func Test_executeDeleteNamespace(t *testing.T) {
 
 	t.Errorf("GetNamespaces() should have been called with Namespace: %s but got %+v", sut.Namespace, firstArg)
 }
+
+func waitTestSetup(mockKubernetesManager *testutils.MockKubernetesManager, forceFinalizers bool) *command.NamespaceCommand {
+	mockKubernetesManager.GetNamespacesRes = []string{"test-1"}
+	sut := command.NewNamespaceCommand(testutils.NewMockFlagProvider(), mockKubernetesManager)
+
+	timeout := "10m"
+	waitTimeout := "1m"
+	wait := true
+	sut.Timeout = &timeout
+	sut.WaitTimeout = &waitTimeout
+	sut.Wait = &wait
+	sut.ForceFinalizers = &forceFinalizers
+	sut.Namespace = "test-1"
+
+	return sut
+}
+
+func stuckNamespaceStatus() *external.NamespaceStatus {
+	return &external.NamespaceStatus{
+		Phase:      "Terminating",
+		Finalizers: []string{"kubernetes"},
+		Conditions: []string{"NamespaceFinalizersRemaining: Some content in the namespace has finalizers remaining: example.com/protect in 1 resource instances"},
+		Resources: []external.NamespaceResource{
+			{Kind: "ConfigMap", Name: "protected", Namespace: "test-1", Finalizers: []string{"example.com/protect"}},
+		},
+	}
+}
+
+func Test_executeWait(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	sut := waitTestSetup(mockKubernetesManager, false)
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if mockKubernetesManager.Called["waitfornamespacedeleted"] != 1 {
+		t.Errorf("WaitForNamespaceDeleted() should have been called once")
+	}
+
+	if mockKubernetesManager.Called["getnamespacestatus"] != 0 {
+		t.Errorf("GetNamespaceStatus() should only be called when waiting times out")
+	}
+}
+
+func Test_executeWaitTimeout(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.WaitForNamespaceDeletedRes = []error{errors.Wrap(external.ErrNamespaceDeleteTimeout, "test-1")}
+	mockKubernetesManager.GetNamespaceStatusRes = stuckNamespaceStatus()
+	sut := waitTestSetup(mockKubernetesManager, false)
+
+	if err := sut.Execute(); err == nil {
+		t.Fatalf("Execute() should error when the namespace is still terminating")
+	}
+
+	if mockKubernetesManager.Called["getnamespacestatus"] != 1 {
+		t.Errorf("GetNamespaceStatus() should have been called once to report what blocks the namespace")
+	}
+
+	if mockKubernetesManager.Called["removefinalizers"] != 0 {
+		t.Errorf("RemoveFinalizers() should not be called without --ForceFinalizers")
+	}
+}
+
+func Test_executeWaitForceFinalizers(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.WaitForNamespaceDeletedRes = []error{errors.Wrap(external.ErrNamespaceDeleteTimeout, "test-1")}
+	mockKubernetesManager.GetNamespaceStatusRes = stuckNamespaceStatus()
+	sut := waitTestSetup(mockKubernetesManager, true)
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error once the finalizers are removed, got %s", err)
+	}
+
+	if mockKubernetesManager.Called["removefinalizers"] != 1 {
+		t.Fatalf("RemoveFinalizers() should have been called once")
+	}
+
+	args := mockKubernetesManager.CalledWith["removefinalizers"][0].(*testutils.KMRemoveFinalizersArgs)
+	if len(args.Resources) != 1 || args.Resources[0].Name != "protected" {
+		t.Errorf("RemoveFinalizers() should have been called with the remaining resources, got %+v", args.Resources)
+	}
+
+	if mockKubernetesManager.Called["waitfornamespacedeleted"] != 2 {
+		t.Errorf("WaitForNamespaceDeleted() should have been called again after removing finalizers")
+	}
+}
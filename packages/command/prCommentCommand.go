@@ -2,8 +2,6 @@ package command
 
 import (
 	"log"
-	"os"
-	"strings"
 
 	"bitbucket.org/centeva/collie/packages/external"
 	"github.com/pkg/errors"
@@ -46,46 +44,60 @@ func NewPRCommentCommand(flagProvider external.IFlagProvider, gitProviderFactory
 	}
 }
 
-func (c *PRCommentCommand) IsCurrent() bool {
-	return len(os.Args) > 1 && strings.EqualFold(os.Args[1], "Comment")
+func (c *PRCommentCommand) Names() []string {
+	return []string{"Comment", "pr-comment"}
 }
 
-func (c *PRCommentCommand) GetFlags() (err error) {
-	if len(os.Args) <= 2 || os.Args[2] == "" {
+func (c *PRCommentCommand) GetFlags(args []string) (err error) {
+	// The GitProvider may come after the flags, so the flags of both providers are registered up front.
+	branch := c.cmd.String("Branch", "", "(required) Source branch of the Pull Request, the head branch on github")
+	comment := c.cmd.String("Comment", "", "(required) Comment message to add to the Pull Request")
+	repo := c.cmd.String("Repo", "", "(required) Repository name")
+	username := c.cmd.String("Username", "", "Optional Username of comment author, the token username on github")
+	clientId := c.cmd.String("ClientId", "", "(required on bitbucket) BitBucket OAuth ClientId/key")
+	secret := c.cmd.String("Secret", "", "(required on bitbucket) BitBucket OAuth Secret")
+	workspace := c.cmd.String("Workspace", "", "(required on bitbucket) BitBucket workspace")
+	password := c.cmd.String("Password", "", "Optional Password of comment author on bitbucket")
+	organization := c.cmd.String("Organization", "", "(required on github) Github Organization")
+	token := c.cmd.String("Token", "", "(required on github) Github token")
+
+	if err = c.cmd.Parse(args); err != nil {
+		return err
+	}
+
+	if c.cmd.Arg(0) == "" {
 		c.cmd.PrintDefaults()
 		return errors.New("Comment must have a GitProvider, must be <bitbucket,github>, check usage.")
 	}
-	c.GitProvider = os.Args[2]
+	c.GitProvider = c.cmd.Arg(0)
 
 	switch c.GitProvider {
 	case "bitbucket":
 		source := &BitBucketSource{
-			Branch:    c.cmd.String("Branch", "", "(required) Source branch of the Pull Request"),
-			ClientId:  c.cmd.String("ClientId", "", "(required) BitBucket OAuth ClientId/key"),
-			Comment:   c.cmd.String("Comment", "", "(required) Comment message to add to the Pull Request"),
-			Repo:      c.cmd.String("Repo", "", "(required) Repository name"),
-			Secret:    c.cmd.String("Secret", "", "(required) BitBucket OAuth Secret"),
-			Workspace: c.cmd.String("Workspace", "", "(required) BitBucket workspace"),
-			Username:  c.cmd.String("Username", "", "Optional Username of comment author"),
-			Password:  c.cmd.String("Password", "", "Optional Password of comment author"),
+			Branch:    branch,
+			ClientId:  clientId,
+			Comment:   comment,
+			Repo:      repo,
+			Secret:    secret,
+			Workspace: workspace,
+			Username:  username,
+			Password:  password,
 		}
 		c.GitSource = source
-		c.cmd.Parse(os.Args[3:])
 		if err := c.ValidateBitbucketFlags(source); err != nil {
 			return errors.Wrapf(err, "Failed to validate flags")
 		}
 	case "github":
 		source := &GithubSource{
-			Organization: c.cmd.String("Organization", "", "(required) Github Organization"),
-			Repo:         c.cmd.String("Repo", "", "(required) Repository name"),
-			Branch:       c.cmd.String("Branch", "", "(required) Head branch of the Pull Request"),
-			Token:        c.cmd.String("Token", "", "(required) Github token"),
-			Username:     c.cmd.String("Username", "", "Token username"),
-			Comment:      c.cmd.String("Comment", "", "(required) Comment message to add to the Pull Request"),
+			Organization: organization,
+			Repo:         repo,
+			Branch:       branch,
+			Token:        token,
+			Username:     username,
+			Comment:      comment,
 		}
 
 		c.GitSource = source
-		c.cmd.Parse(os.Args[3:])
 		if err := c.ValidateGithubFlags(source); err != nil {
 			return errors.Wrap(err, "failed to validate flags")
 		}
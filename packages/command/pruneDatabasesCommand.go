@@ -0,0 +1,213 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
+	"github.com/pkg/errors"
+)
+
+type PruneDatabasesCommand struct {
+	gitProviderFactory *external.GitProviderFactory
+	kubernetesManager  external.IKubernetesManager
+	databaseManager    external.IDatabaseManager
+	fileReader         external.IFileReader
+	cmd                external.IFlagSet
+
+	Pattern              *string
+	CleanupConfigPath    *string
+	Kubeconfig           *string
+	Context              *string
+	KubeNamespace        *string
+	ConnectionString     *string
+	ConnectionStringFile *string
+	DryRun               *bool
+	Timeout              *string
+	PushGateway          *string
+	CleanupConfig        *CleanupConfig
+}
+
+func NewPruneDatabasesCommand(flagProvider external.IFlagProvider, kubernetesManager external.IKubernetesManager, databaseManager external.IDatabaseManager, fileReader external.IFileReader, gitProviderFactory *external.GitProviderFactory) *PruneDatabasesCommand {
+	return &PruneDatabasesCommand{
+		gitProviderFactory: gitProviderFactory,
+		kubernetesManager:  kubernetesManager,
+		databaseManager:    databaseManager,
+		fileReader:         fileReader,
+		cmd:                flagProvider.NewFlagSet("PruneDatabases", "Drop databases matching --Pattern that have no namespace and no open Pull Request left, Usage: PruneDatabases --Pattern <regex> [args]"),
+	}
+}
+
+func (p *PruneDatabasesCommand) Names() []string {
+	return []string{"PruneDatabases", "prune-databases"}
+}
+
+func (p *PruneDatabasesCommand) GetFlags(args []string) (err error) {
+	p.Pattern = p.cmd.String("Pattern", "", "Regular expression the database names to prune must match, a capture group selects the branch name within the database name")
+	p.CleanupConfigPath = p.cmd.String("CleanupConfig", "", "Path to a cleanupConfig file, databases of branches with an open Pull Request in its gitProvider are kept and its cluster settings are used")
+	p.Kubeconfig = p.cmd.String("Kubeconfig", "", "Path to kubeconfig file, overrides kubeconfig in the cleanupConfig file")
+	p.Context = p.cmd.String("Context", "", "Kubeconfig context to use, overrides context in the cleanupConfig file")
+	p.KubeNamespace = p.cmd.String("KubeNamespace", "", "Override the namespace of the kubeconfig context")
+	p.ConnectionString = p.cmd.String("ConnectionString", "", "Database connectionString, postgres://, mysql:// or sqlserver://, prefer --ConnectionStringFile or "+external.ConnectionStringEnv+" to keep it out of the process list")
+	p.ConnectionStringFile = p.cmd.String("ConnectionStringFile", "", "Path to a file containing the database connectionString, such as a mounted Secret")
+	p.DryRun = p.cmd.Bool("DryRun", false, "Only log the databases that would be dropped")
+	p.Timeout = p.cmd.String("Timeout", DefaultTeardownTimeouts.Database.String(), "Timeout for connecting to the database and for dropping each database, 0 waits forever")
+	p.PushGateway = p.cmd.String("PushGateway", "", "Prometheus pushgateway url to push metrics to")
+
+	if err = p.cmd.Parse(args); err != nil {
+		return err
+	}
+
+	if *p.Pattern == "" {
+		p.cmd.PrintDefaults()
+		return errors.New("PruneDatabases requires a --Pattern")
+	}
+
+	if *p.CleanupConfigPath != "" {
+		if p.CleanupConfig, err = readConfigFile(p.fileReader, *p.CleanupConfigPath); err != nil {
+			return errors.Wrap(err, "Failed to read config")
+		}
+	}
+
+	p.ConnectionString, err = resolveConnectionString(p.fileReader, p.ConnectionString, p.ConnectionStringFile)
+	return
+}
+
+func (p *PruneDatabasesCommand) Execute() (err error) {
+	defer pushMetrics(p.PushGateway, "collie_prunedatabases")
+
+	pattern, err := regexp.Compile(*p.Pattern)
+	if err != nil {
+		return errors.Wrap(err, "Failed to parse Pattern")
+	}
+
+	timeout, err := parseTimeout(p.Timeout, "Timeout", DefaultTeardownTimeouts.Database)
+	if err != nil {
+		return err
+	}
+
+	if err = connectCluster(p.kubernetesManager, p.clusterConfig()); err != nil {
+		return err
+	}
+
+	if err = connectDatabase(p.databaseManager, *p.ConnectionString, timeout); err != nil {
+		return errors.Wrap(err, "Execute failed to connect")
+	}
+	defer p.databaseManager.Close(context.Background())
+
+	// The databases are listed first, so a preview environment created while the namespaces and branches are listed
+	// has a namespace or branch that is listed too and its database isn't taken for an orphan.
+	ctx, cancel := contextWithTimeout(timeout)
+	databases, err := p.databaseManager.ListDatabases(ctx)
+	cancel()
+
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := p.kubernetesManager.GetNamespaces(context.Background(), "")
+	if err != nil {
+		return errors.Wrap(err, "Failed to get namespaces")
+	}
+
+	var branches []string
+	if p.CleanupConfig != nil && p.CleanupConfig.GitProvider != nil {
+		if branches, err = openBranches(p.gitProviderFactory, p.CleanupConfig.GitProvider); err != nil {
+			return err
+		}
+	}
+
+	orphans := orphanedDatabases(pattern, databases, append(namespaces, branches...))
+
+	if len(orphans) == 0 {
+		log.Printf("No orphaned databases matching %s", pattern)
+		return
+	}
+
+	if *p.DryRun {
+		log.Printf("Would drop orphaned databases %s", orphans)
+		return
+	}
+
+	var allErrs []string
+	for _, database := range orphans {
+		ctx, cancel := contextWithTimeout(timeout)
+		dropped, err := p.databaseManager.DeleteDatabase(ctx, database)
+		if err != nil {
+			reconnectDatabase(ctx, p.databaseManager, *p.ConnectionString, timeout)
+		}
+		cancel()
+
+		if err != nil {
+			allErrs = append(allErrs, fmt.Sprintf(" %d) %s", len(allErrs), err))
+			continue
+		}
+
+		if !dropped {
+			log.Printf("Database %s does not exist", database)
+			continue
+		}
+
+		metrics.DatabasesDropped.Inc()
+		log.Printf("Database %s deleted", database)
+	}
+
+	if len(allErrs) > 0 {
+		allErr := errors.New(strings.Join(allErrs, ""))
+		return errors.Wrapf(allErr, "failed to drop %d of %d orphaned databases", len(allErrs), len(orphans))
+	}
+
+	return
+}
+
+// clusterConfig is the cluster from the cleanupConfig file, if any, with the cluster flags applied.
+func (p *PruneDatabasesCommand) clusterConfig() external.ClusterConfig {
+	cluster := clusterFlags(p.Kubeconfig, p.Context, p.KubeNamespace)
+
+	if p.CleanupConfig == nil {
+		return cluster
+	}
+
+	config := p.CleanupConfig.Cluster()
+
+	if cluster.Kubeconfig != "" {
+		config.Kubeconfig = cluster.Kubeconfig
+	}
+
+	if cluster.Context != "" {
+		config.Context = cluster.Context
+	}
+
+	if cluster.Namespace != "" {
+		config.Namespace = cluster.Namespace
+	}
+
+	return config
+}
+
+// orphanedDatabases returns the databases matching the pattern whose branch name is not in keep. The branch name is
+// the pattern's first capture group, or the whole database name when the pattern has none.
+func orphanedDatabases(pattern *regexp.Regexp, databases []string, keep []string) (orphans []string) {
+	for _, database := range databases {
+		match := pattern.FindStringSubmatch(database)
+
+		if match == nil {
+			continue
+		}
+
+		name := database
+		if len(match) > 1 {
+			name = match[1]
+		}
+
+		if !Contains(keep, name) {
+			orphans = append(orphans, database)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,186 @@
+package command_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"bitbucket.org/centeva/collie/packages/command"
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
+	"bitbucket.org/centeva/collie/testutils"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func pruneDatabasesTestSetup(pattern string, dryRun bool) (*command.PruneDatabasesCommand, *testutils.MockKubernetesManager, *testutils.MockDatabaseManager, *testutils.MockGitProvider) {
+	mockBitbucketManager := testutils.NewMockGitProvider()
+	mockGitProviderFactory := &external.GitProviderFactory{
+		BitbucketManager: mockBitbucketManager,
+	}
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	sut := command.NewPruneDatabasesCommand(testutils.NewMockFlagProvider(), mockKubernetesManager, mockDatabaseManager, testutils.NewMockFileReader(""), mockGitProviderFactory)
+
+	connectionString := "testConnString"
+	empty := ""
+	sut.Pattern = &pattern
+	sut.DryRun = &dryRun
+	sut.ConnectionString = &connectionString
+	sut.Kubeconfig = &empty
+	sut.Context = &empty
+	sut.KubeNamespace = &empty
+	sut.PushGateway = &empty
+
+	return sut, mockKubernetesManager, mockDatabaseManager, mockBitbucketManager
+}
+
+func droppedDatabases(mockDatabaseManager *testutils.MockDatabaseManager) (dropped []string) {
+	for _, arg := range mockDatabaseManager.CalledWith["deletedatabase"] {
+		dropped = append(dropped, arg.(*testutils.DMDeleteDatabaseArgs).Database)
+	}
+
+	return
+}
+
+func Test_PruneDatabases(t *testing.T) {
+	sut, mockKubernetesManager, mockDatabaseManager, mockBitbucketManager := pruneDatabasesTestSetup(`^app_(.+)$`, false)
+	mockKubernetesManager.GetNamespacesRes = []string{"feature-1", "kube-system"}
+	mockBitbucketManager.GetBranchesRes = []string{"feature/2"}
+	mockDatabaseManager.ListDatabasesRes = []string{"app_feature-1", "app_feature-2", "app_feature-3", "postgres", "other_feature-4"}
+	sut.CleanupConfig = &command.CleanupConfig{
+		GitProvider: &command.ConfigGitProvider{Bitbucket: &command.ConfigBitbucketArgs{Workspace: "testWorkspace", Repo: "testRepo"}},
+	}
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if dropped := droppedDatabases(mockDatabaseManager); !reflect.DeepEqual(dropped, []string{"app_feature-3"}) {
+		t.Errorf("Execute() should only drop databases without a namespace or open Pull Request, got %v", dropped)
+	}
+
+	if mockDatabaseManager.Called["close"] != 1 {
+		t.Errorf("Close() should have been called once")
+	}
+}
+
+func Test_PruneDatabasesListsDatabasesFirst(t *testing.T) {
+	sut, mockKubernetesManager, mockDatabaseManager, mockBitbucketManager := pruneDatabasesTestSetup(`^app_(.+)$`, false)
+	sut.CleanupConfig = &command.CleanupConfig{
+		GitProvider: &command.ConfigGitProvider{Bitbucket: &command.ConfigBitbucketArgs{Workspace: "testWorkspace", Repo: "testRepo"}},
+	}
+
+	listedBefore := -1
+	mockDatabaseManager.ListDatabasesHook = func() {
+		listedBefore = mockKubernetesManager.Called["getnamespaces"] + mockBitbucketManager.Called["getopenprbranches"]
+	}
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if listedBefore != 0 || mockKubernetesManager.Called["getnamespaces"] != 1 || mockBitbucketManager.Called["getopenprbranches"] != 1 {
+		t.Errorf("Execute() should list the databases before the namespaces and branches, got %d listed before", listedBefore)
+	}
+}
+
+func Test_PruneDatabasesWithoutCaptureGroup(t *testing.T) {
+	sut, mockKubernetesManager, mockDatabaseManager, _ := pruneDatabasesTestSetup(`^feature-`, false)
+	mockKubernetesManager.GetNamespacesRes = []string{"feature-1"}
+	mockDatabaseManager.ListDatabasesRes = []string{"feature-1", "feature-2", "postgres"}
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if dropped := droppedDatabases(mockDatabaseManager); !reflect.DeepEqual(dropped, []string{"feature-2"}) {
+		t.Errorf("Execute() should compare the whole database name with the namespaces, got %v", dropped)
+	}
+}
+
+func Test_PruneDatabasesDryRun(t *testing.T) {
+	sut, _, mockDatabaseManager, _ := pruneDatabasesTestSetup(`^feature-`, true)
+	mockDatabaseManager.ListDatabasesRes = []string{"feature-1", "feature-2"}
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if mockDatabaseManager.Called["deletedatabase"] != 0 {
+		t.Errorf("Execute() should not drop databases in a dry run, got %v", droppedDatabases(mockDatabaseManager))
+	}
+}
+
+func Test_PruneDatabasesContinuesAfterFailure(t *testing.T) {
+	sut, _, mockDatabaseManager, _ := pruneDatabasesTestSetup(`^feature-`, false)
+	mockDatabaseManager.ListDatabasesRes = []string{"feature-1", "feature-2"}
+	mockDatabaseManager.DeleteDatabaseErrs = map[string]error{"feature-1": errors.New("database is being accessed by other users")}
+
+	err := sut.Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "failed to drop 1 of 2 orphaned databases") {
+		t.Errorf("Execute() should report the failed database, got %v", err)
+	}
+
+	if dropped := droppedDatabases(mockDatabaseManager); !reflect.DeepEqual(dropped, []string{"feature-1", "feature-2"}) {
+		t.Errorf("Execute() should keep dropping after a failure, got %v", dropped)
+	}
+}
+
+func Test_PruneDatabasesMissingDatabase(t *testing.T) {
+	sut, _, mockDatabaseManager, _ := pruneDatabasesTestSetup(`^feature-`, false)
+	mockDatabaseManager.ListDatabasesRes = []string{"feature-1", "feature-2"}
+	mockDatabaseManager.DeleteDatabaseMissing = map[string]bool{"feature-1": true}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	dropped := testutil.ToFloat64(metrics.DatabasesDropped)
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if count := testutil.ToFloat64(metrics.DatabasesDropped) - dropped; count != 1 {
+		t.Errorf("Execute() should count only the dropped database, got %v", count)
+	}
+
+	if !strings.Contains(logs.String(), "Database feature-1 does not exist") || strings.Contains(logs.String(), "Database feature-1 deleted") {
+		t.Errorf("Execute() should log the database that was gone as missing, got %s", logs.String())
+	}
+}
+
+func Test_PruneDatabasesReconnectsAfterTimeout(t *testing.T) {
+	sut, _, mockDatabaseManager, _ := pruneDatabasesTestSetup(`^feature-`, false)
+	timeout := "10ms"
+	sut.Timeout = &timeout
+	mockDatabaseManager.ListDatabasesRes = []string{"feature-1", "feature-2"}
+	mockDatabaseManager.DeleteDatabaseTimesOut = map[string]bool{"feature-1": true}
+
+	err := sut.Execute()
+
+	if err == nil || !strings.Contains(err.Error(), "failed to drop 1 of 2 orphaned databases") {
+		t.Errorf("Execute() should report the database that timed out, got %v", err)
+	}
+
+	if mockDatabaseManager.Called["connect"] != 2 || mockDatabaseManager.Called["close"] != 2 {
+		t.Errorf("Execute() should reconnect after a timeout, got %v", mockDatabaseManager.Called)
+	}
+}
+
+func Test_PruneDatabasesInvalidPattern(t *testing.T) {
+	sut, _, mockDatabaseManager, _ := pruneDatabasesTestSetup(`(`, false)
+
+	if err := sut.Execute(); err == nil {
+		t.Errorf("Execute() should reject an invalid pattern")
+	}
+
+	if mockDatabaseManager.Called["connect"] != 0 {
+		t.Errorf("Execute() should not connect with an invalid pattern")
+	}
+}
@@ -0,0 +1,25 @@
+package command
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// ParseSchedule accepts either a duration (1h30m) or a standard 5 field cron expression (0 2 * * *).
+func ParseSchedule(interval string) (schedule cron.Schedule, err error) {
+	if dur, err := time.ParseDuration(interval); err == nil {
+		if dur < time.Second {
+			return nil, errors.Errorf("Interval must be at least 1s got '%s'", interval)
+		}
+
+		return cron.Every(dur), nil
+	}
+
+	if schedule, err = cron.ParseStandard(interval); err != nil {
+		return nil, errors.Wrapf(err, "Interval '%s' is not a duration or cron expression", interval)
+	}
+
+	return
+}
@@ -0,0 +1,41 @@
+package command_test
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/centeva/collie/packages/command"
+)
+
+func Test_ParseSchedule(t *testing.T) {
+	start := time.Date(2021, 8, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		interval string
+		want     time.Time
+		wantErr  bool
+	}{
+		{name: "should parse duration", interval: "1h", want: start.Add(time.Hour)},
+		{name: "should parse cron", interval: "0 2 * * *", want: time.Date(2021, 8, 2, 2, 0, 0, 0, time.UTC)},
+		{name: "should parse descriptor", interval: "@daily", want: time.Date(2021, 8, 2, 0, 0, 0, 0, time.UTC)},
+		{name: "should error on short duration", interval: "10ms", wantErr: true},
+		{name: "should error on garbage", interval: "sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := command.ParseSchedule(tt.interval)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got := schedule.Next(start); !got.Equal(tt.want) {
+				t.Errorf("ParseSchedule().Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
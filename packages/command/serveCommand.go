@@ -0,0 +1,213 @@
+package command
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"github.com/pkg/errors"
+)
+
+type webhookParser func(header http.Header, body []byte, secret string) (*external.PullRequestEvent, error)
+
+type ServeCommand struct {
+	kubernetesManager external.IKubernetesManager
+	databaseManager   external.IDatabaseManager
+	fileReader        external.IFileReader
+	cmd               external.IFlagSet
+
+	cleanupConfigPath string
+	Address           *string
+	NamespaceLabel    *string
+	CleanupConfig     *CleanupConfig
+
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	inFlight   map[string]bool
+	teardownMu sync.Mutex
+}
+
+func NewServeCommand(flagProvider external.IFlagProvider, kubernetesManager external.IKubernetesManager, databaseManager external.IDatabaseManager, fileReader external.IFileReader) *ServeCommand {
+	return &ServeCommand{
+		kubernetesManager: kubernetesManager,
+		databaseManager:   databaseManager,
+		fileReader:        fileReader,
+		inFlight:          make(map[string]bool),
+		cmd:               flagProvider.NewFlagSet("Serve", "Listen for Pull Request webhooks and cleanup the namespace when a Pull Request is closed, Usage: Serve <CleanupConfigPath>"),
+	}
+}
+
+func (s *ServeCommand) Names() []string {
+	return []string{"Serve"}
+}
+
+func (s *ServeCommand) GetFlags(args []string) (err error) {
+	s.Address = s.cmd.String("Address", ":8080", "Address the webhook server listens on")
+	s.NamespaceLabel = s.cmd.String("NamespaceLabel", "dev.centeva.meta=PullRequest", "Set the label used to check if a namespace can be cleaned up")
+
+	if err = s.cmd.Parse(args); err != nil {
+		return err
+	}
+
+	if s.cmd.Arg(0) == "" {
+		s.cmd.PrintDefaults()
+		return errors.New("Serve requires a cleanupConfig file, check usage.")
+	}
+
+	s.cleanupConfigPath = s.cmd.Arg(0)
+
+	if s.CleanupConfig, err = readConfigFile(s.fileReader, s.cleanupConfigPath); err != nil {
+		return errors.Wrap(err, "Failed to read config")
+	}
+
+	if err = validateJobMode(s.CleanupConfig); err != nil {
+		return err
+	}
+
+	if s.CleanupConfig.Webhook == nil || s.CleanupConfig.Webhook.Secret == "" {
+		return errors.New("Serve requires webhook.secret in the cleanupConfig file")
+	}
+
+	return
+}
+
+func (s *ServeCommand) Execute() (err error) {
+	if err = connectCluster(s.kubernetesManager, s.CleanupConfig.Cluster()); err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    *s.Address,
+		Handler: s.Handler(),
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-stop
+		log.Printf("Shutting down webhook server")
+		server.Shutdown(context.Background())
+	}()
+
+	log.Printf("Listening for webhooks on %s", *s.Address)
+
+	if err = server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "Webhook server failed")
+	}
+
+	s.Wait()
+	return nil
+}
+
+func (s *ServeCommand) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webhook/bitbucket", s.handleWebhook(external.ParseBitbucketWebhook))
+	mux.HandleFunc("/webhook/github", s.handleWebhook(external.ParseGithubWebhook))
+	registerHealthHandlers(mux, func() bool { return true })
+	return mux
+}
+
+// Wait blocks until every cleanup started by a webhook has finished.
+func (s *ServeCommand) Wait() {
+	s.wg.Wait()
+}
+
+func (s *ServeCommand) handleWebhook(parse webhookParser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		event, err := parse(r.Header, body, s.CleanupConfig.Webhook.Secret)
+		if err != nil {
+			log.Printf("Rejected webhook: %s", err)
+			if errors.Cause(err) == external.ErrInvalidSignature {
+				http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			} else {
+				http.Error(w, "Invalid payload", http.StatusBadRequest)
+			}
+			return
+		}
+
+		if event == nil || !event.Closed || event.Branch == "" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		name := CleanBranch(event.Branch)
+
+		if !s.start(name) {
+			log.Printf("Cleanup of %s already in progress", name)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		go s.cleanupBranch(name)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (s *ServeCommand) start(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inFlight[name] {
+		return false
+	}
+
+	s.inFlight[name] = true
+	s.wg.Add(1)
+	return true
+}
+
+func (s *ServeCommand) cleanupBranch(name string) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.inFlight, name)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	namespaces, err := s.kubernetesManager.GetNamespaces(context.Background(), *s.NamespaceLabel)
+	if err != nil {
+		log.Printf("Failed to get namespaces: %s", err)
+		return
+	}
+
+	if !Contains(namespaces, name) {
+		log.Printf("Namespace %s does not exist; Nothing to cleanup", name)
+		return
+	}
+
+	log.Printf("Cleaning up %s", name)
+
+	if s.CleanupConfig.Mode == CleanupModeInProcess {
+		// The database connection is not safe for concurrent use, cleanup one branch at a time.
+		s.teardownMu.Lock()
+		err = cleanupInProcess(context.Background(), s.kubernetesManager, s.databaseManager, s.CleanupConfig, []string{name})
+		s.teardownMu.Unlock()
+	} else {
+		err = createCleanupJob(context.Background(), s.kubernetesManager, s.CleanupConfig.JobConfig, name)
+	}
+
+	if err != nil {
+		log.Printf("Failed to cleanup %s: %s", name, err)
+		return
+	}
+
+	log.Printf("Cleaned up %s", name)
+}
@@ -0,0 +1,241 @@
+package command_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/centeva/collie/packages/command"
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/testutils"
+)
+
+const testWebhookSecret = "testSecret"
+
+func serveTestSetup(mockKubernetesManager *testutils.MockKubernetesManager) *command.ServeCommand {
+	mockFlagProvider := testutils.NewMockFlagProvider()
+	sut := command.NewServeCommand(mockFlagProvider, mockKubernetesManager, testutils.NewMockDatabaseManager(), testutils.NewMockFileReader("testFile"))
+
+	namespaceLabel := "testLabel"
+	sut.NamespaceLabel = &namespaceLabel
+	sut.CleanupConfig = &command.CleanupConfig{
+		JobConfig: &external.CleanupJobConfig{
+			Image:        "testImage",
+			JobNamespace: "testJobNamespace",
+		},
+		Webhook: &command.ConfigWebhook{Secret: testWebhookSecret},
+	}
+
+	return sut
+}
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(t *testing.T, sut *command.ServeCommand, path string, payload string, headers map[string]string) *httptest.ResponseRecorder {
+	body, err := ioutil.ReadFile("testdata/" + payload)
+	if err != nil {
+		t.Fatalf("Failed to read payload %s: %s", payload, err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	for key, value := range headers {
+		if value == "sign" {
+			value = sign(testWebhookSecret, body)
+		}
+		req.Header.Set(key, value)
+	}
+
+	res := httptest.NewRecorder()
+	sut.Handler().ServeHTTP(res, req)
+	sut.Wait()
+
+	return res
+}
+
+func Test_ServeBitbucketFulfilled(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"feature-uni-1234-test", "other"}
+	sut := serveTestSetup(mockKubernetesManager)
+
+	res := postWebhook(t, sut, "/webhook/bitbucket", "bitbucket_pullrequest_fulfilled.json", map[string]string{
+		"X-Event-Key":     "pullrequest:fulfilled",
+		"X-Hub-Signature": "sign",
+	})
+
+	if res.Code != http.StatusAccepted {
+		t.Errorf("Serve should respond %d but got %d", http.StatusAccepted, res.Code)
+	}
+
+	if mockKubernetesManager.Called["createcleanupjob"] != 1 {
+		t.Fatalf("CreateCleanupJob() should have been called once")
+	}
+
+	args := mockKubernetesManager.CalledWith["createcleanupjob"][0].(*testutils.KMCreateCleanupJobArgs)
+	if args.Config.Name != "feature-uni-1234-test" {
+		t.Errorf("CreateCleanupJob() should have been called with Name: %s but got %+v", "feature-uni-1234-test", args.Config)
+	}
+}
+
+func Test_ServeWithoutJobConfig(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"feature-uni-1234-test"}
+	sut := serveTestSetup(mockKubernetesManager)
+	sut.CleanupConfig.JobConfig = nil
+
+	postWebhook(t, sut, "/webhook/bitbucket", "bitbucket_pullrequest_fulfilled.json", map[string]string{
+		"X-Event-Key":     "pullrequest:fulfilled",
+		"X-Hub-Signature": "sign",
+	})
+
+	if mockKubernetesManager.Called["createcleanupjob"] != 0 {
+		t.Errorf("CreateCleanupJob() should not be called without a job config")
+	}
+}
+
+func Test_ServeGetFlagsJobMode(t *testing.T) {
+	const webhook = "webhook:\n  secret: testSecret\n"
+	const template = "spec:\n  template:\n    spec:\n      containers:\n      - name: teardown\n        image: collie:template\n"
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "job image", config: webhook + "job:\n  image: collie\n"},
+		{name: "template image", config: webhook + "job:\n  template: template.yaml\n"},
+		{name: "in process without job", config: webhook + "mode: inProcess\n"},
+		{name: "without job", config: webhook, wantErr: true},
+		{name: "without image", config: webhook + "job:\n  namespace: collie\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockFileReader := testutils.NewMockFileReader(tt.config)
+			mockFileReader.Files["template.yaml"] = []byte(template)
+			sut := command.NewServeCommand(testutils.NewMockFlagProvider(), testutils.NewMockKubernetesManager(), testutils.NewMockDatabaseManager(), mockFileReader)
+
+			if err := sut.GetFlags([]string{"config.yaml"}); tt.wantErr != (err != nil) {
+				t.Errorf("GetFlags() should error: %t, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_ServeGithubClosed(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"feature-uni-1234-test"}
+	sut := serveTestSetup(mockKubernetesManager)
+
+	res := postWebhook(t, sut, "/webhook/github", "github_pull_request_closed.json", map[string]string{
+		"X-GitHub-Event":      "pull_request",
+		"X-Hub-Signature-256": "sign",
+	})
+
+	if res.Code != http.StatusAccepted {
+		t.Errorf("Serve should respond %d but got %d", http.StatusAccepted, res.Code)
+	}
+
+	if mockKubernetesManager.Called["createcleanupjob"] != 1 {
+		t.Errorf("CreateCleanupJob() should have been called once")
+	}
+}
+
+func Test_ServeIgnoresOpened(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"feature-uni-1234-test"}
+	sut := serveTestSetup(mockKubernetesManager)
+
+	res := postWebhook(t, sut, "/webhook/github", "github_pull_request_opened.json", map[string]string{
+		"X-GitHub-Event":      "pull_request",
+		"X-Hub-Signature-256": "sign",
+	})
+
+	if res.Code != http.StatusOK {
+		t.Errorf("Serve should respond %d but got %d", http.StatusOK, res.Code)
+	}
+
+	if mockKubernetesManager.Called["createcleanupjob"] != 0 {
+		t.Errorf("CreateCleanupJob() should not have been called")
+	}
+}
+
+func Test_ServeSkipsMissingNamespace(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"other"}
+	sut := serveTestSetup(mockKubernetesManager)
+
+	postWebhook(t, sut, "/webhook/github", "github_pull_request_closed.json", map[string]string{
+		"X-GitHub-Event":      "pull_request",
+		"X-Hub-Signature-256": "sign",
+	})
+
+	if mockKubernetesManager.Called["createcleanupjob"] != 0 {
+		t.Errorf("CreateCleanupJob() should not have been called")
+	}
+}
+
+func Test_ServeInvalidSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+	}{
+		{name: "missing signature", signature: ""},
+		{name: "wrong signature", signature: sign("wrongSecret", []byte("{}"))},
+		{name: "malformed signature", signature: "sha256=zz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockKubernetesManager := testutils.NewMockKubernetesManager()
+			mockKubernetesManager.GetNamespacesRes = []string{"feature-uni-1234-test"}
+			sut := serveTestSetup(mockKubernetesManager)
+
+			res := postWebhook(t, sut, "/webhook/bitbucket", "bitbucket_pullrequest_fulfilled.json", map[string]string{
+				"X-Event-Key":     "pullrequest:fulfilled",
+				"X-Hub-Signature": tt.signature,
+			})
+
+			if res.Code != http.StatusUnauthorized {
+				t.Errorf("Serve should respond %d but got %d", http.StatusUnauthorized, res.Code)
+			}
+
+			if mockKubernetesManager.Called["createcleanupjob"] != 0 {
+				t.Errorf("CreateCleanupJob() should not have been called")
+			}
+		})
+	}
+}
+
+func Test_ServeMetrics(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"feature-uni-1234-test"}
+	sut := serveTestSetup(mockKubernetesManager)
+
+	postWebhook(t, sut, "/webhook/github", "github_pull_request_closed.json", map[string]string{
+		"X-GitHub-Event":      "pull_request",
+		"X-Hub-Signature-256": "sign",
+	})
+
+	res := httptest.NewRecorder()
+	sut.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Serve should respond %d on /metrics but got %d", http.StatusOK, res.Code)
+	}
+
+	for _, name := range []string{"collie_namespaces_deleted_total", "collie_cleanup_job_duration_seconds"} {
+		if !strings.Contains(res.Body.String(), name) {
+			t.Errorf("/metrics should contain %s", name)
+		}
+	}
+}
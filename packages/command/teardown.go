@@ -0,0 +1,374 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
+	"github.com/pkg/errors"
+)
+
+type CleanupMode string
+
+const (
+	// CleanupModeJob creates a cleanup job per namespace that runs collie's own image.
+	CleanupModeJob CleanupMode = "job"
+	// CleanupModeInProcess deletes the namespace and database from the running collie process.
+	CleanupModeInProcess CleanupMode = "inProcess"
+)
+
+// TeardownStep is one step of a teardown, steps run in order and stop at the first failure.
+type TeardownStep struct {
+	Name    string
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+type TeardownStepStatus string
+
+const (
+	TeardownStepSucceeded TeardownStepStatus = "succeeded"
+	TeardownStepFailed    TeardownStepStatus = "failed"
+	TeardownStepTimedOut  TeardownStepStatus = "timedOut"
+	TeardownStepSkipped   TeardownStepStatus = "skipped"
+)
+
+type TeardownStepResult struct {
+	Name     string
+	Status   TeardownStepStatus
+	Duration time.Duration
+	Err      error
+}
+
+// TeardownTimeouts are the per step timeouts of the default teardown.
+type TeardownTimeouts struct {
+	ScaleDown time.Duration
+	Namespace time.Duration
+	Database  time.Duration
+	Backup    time.Duration
+}
+
+var DefaultTeardownTimeouts = TeardownTimeouts{
+	ScaleDown: time.Minute,
+	Namespace: 5 * time.Minute,
+	Database:  time.Minute,
+	Backup:    10 * time.Minute,
+}
+
+// DefaultTeardownActionTimeout is used for teardown actions without a timeout.
+const DefaultTeardownActionTimeout = time.Minute
+
+// ParseTeardownTimeouts fills the configured step timeouts in over the defaults.
+func ParseTeardownTimeouts(config *external.StepTimeouts) (timeouts TeardownTimeouts, err error) {
+	timeouts = DefaultTeardownTimeouts
+	if config == nil {
+		return
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"scaleDown", config.ScaleDown, &timeouts.ScaleDown},
+		{"namespace", config.Namespace, &timeouts.Namespace},
+		{"database", config.Database, &timeouts.Database},
+		{"backup", config.Backup, &timeouts.Backup},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+
+		if *timeout.dest, err = time.ParseDuration(timeout.value); err != nil {
+			return timeouts, errors.Wrapf(err, "Invalid %s step timeout", timeout.name)
+		}
+	}
+
+	return
+}
+
+// parseTimeout parses a duration flag, an unset or empty flag is the fallback.
+func parseTimeout(flag *string, name string, fallback time.Duration) (timeout time.Duration, err error) {
+	if flag == nil || *flag == "" {
+		return fallback, nil
+	}
+
+	if timeout, err = time.ParseDuration(*flag); err != nil {
+		return 0, errors.Wrapf(err, "Invalid %s", name)
+	}
+
+	return
+}
+
+// teardownSteps returns the default teardown: scale down workloads, delete the namespace and wait for it to
+// terminate, terminate database connections, back up the database when backup is set, drop the database, then drop
+// the role named like it when dropRole is set. The configured actions that need the namespace run before it is
+// deleted, the others after these.
+func teardownSteps(kubernetesManager external.IKubernetesManager, databaseManager external.IDatabaseManager, name string, timeouts TeardownTimeouts, backup *external.BackupConfig, dropRole bool, actions []teardownActionStep) []TeardownStep {
+	steps := []TeardownStep{
+		{
+			Name:    "scale-down",
+			Timeout: timeouts.ScaleDown,
+			Run: func(ctx context.Context) error {
+				return kubernetesManager.ScaleDownWorkloads(ctx, name)
+			},
+		},
+	}
+
+	steps = appendActionSteps(steps, name, actions, true)
+
+	steps = append(steps, []TeardownStep{
+		{
+			Name: "delete-namespace",
+			// Leave time to report what blocks the namespace after waiting for it times out.
+			Timeout: timeouts.Namespace + time.Minute,
+			Run: func(ctx context.Context) error {
+				namespaces, err := kubernetesManager.GetNamespaces(ctx, "")
+				if err != nil {
+					return err
+				}
+
+				if !Contains(namespaces, name) {
+					log.Printf("[%s] namespace does not exist", name)
+					return nil
+				}
+
+				if err = kubernetesManager.DeleteNamespace(ctx, name); err != nil {
+					return err
+				}
+
+				if err = waitForNamespaceDeleted(ctx, kubernetesManager, name, timeouts.Namespace, false); err != nil {
+					return err
+				}
+
+				metrics.NamespacesDeleted.Inc()
+				return nil
+			},
+		},
+		{
+			Name:    "terminate-connections",
+			Timeout: timeouts.Database,
+			Run: func(ctx context.Context) error {
+				return databaseManager.TerminateConnections(ctx, name)
+			},
+		},
+	}...)
+
+	if backup != nil {
+		steps = append(steps, TeardownStep{
+			Name:    "backup-database",
+			Timeout: timeouts.Backup,
+			Run: func(ctx context.Context) error {
+				return backupDatabase(ctx, databaseManager, *backup, name)
+			},
+		})
+	}
+
+	steps = append(steps, TeardownStep{
+		Name:    "drop-database",
+		Timeout: timeouts.Database,
+		Run: func(ctx context.Context) error {
+			dropped, err := databaseManager.DeleteDatabase(ctx, name)
+			if err != nil {
+				return err
+			}
+
+			if !dropped {
+				log.Printf("[%s] database does not exist", name)
+				return nil
+			}
+
+			metrics.DatabasesDropped.Inc()
+			return nil
+		},
+	})
+
+	if dropRole {
+		steps = append(steps, TeardownStep{
+			Name:    "drop-role",
+			Timeout: timeouts.Database,
+			Run: func(ctx context.Context) error {
+				return databaseManager.DropRole(ctx, name)
+			},
+		})
+	}
+
+	return appendActionSteps(steps, name, actions, false)
+}
+
+// appendActionSteps appends the actions that run before the namespace is deleted, or the ones that run after.
+func appendActionSteps(steps []TeardownStep, name string, actions []teardownActionStep, beforeNamespace bool) []TeardownStep {
+	for _, action := range actions {
+		if action.beforeNamespace != beforeNamespace {
+			continue
+		}
+
+		action := action
+		steps = append(steps, TeardownStep{
+			Name:    action.name,
+			Timeout: action.timeout,
+			Run: func(ctx context.Context) error {
+				return action.action.Teardown(ctx, name)
+			},
+		})
+	}
+
+	return steps
+}
+
+type teardownActionStep struct {
+	name            string
+	timeout         time.Duration
+	beforeNamespace bool
+	action          external.ITeardownAction
+}
+
+// teardownActionSteps validates the configured teardown actions and resolves their timeouts.
+func teardownActionSteps(configs []external.TeardownActionConfig) (steps []teardownActionStep, err error) {
+	for _, config := range configs {
+		action, err := external.NewTeardownAction(config)
+		if err != nil {
+			return nil, err
+		}
+
+		timeout := DefaultTeardownActionTimeout
+		if config.Timeout != "" {
+			if timeout, err = time.ParseDuration(config.Timeout); err != nil {
+				return nil, errors.Wrapf(err, "Invalid timeout for %s teardown action", config.StepName())
+			}
+		}
+
+		steps = append(steps, teardownActionStep{name: config.StepName(), timeout: timeout, beforeNamespace: config.BeforeNamespace(), action: action})
+	}
+
+	return
+}
+
+// RunTeardownSteps runs the steps in order and logs the status of each, the steps after a failed one are skipped.
+// Each step's context is cancelled when ctx is done.
+func RunTeardownSteps(ctx context.Context, name string, steps []TeardownStep) (results []TeardownStepResult, err error) {
+	for i, step := range steps {
+		if err != nil {
+			log.Printf("[%s] %d/%d %s: %s", name, i+1, len(steps), step.Name, TeardownStepSkipped)
+			results = append(results, TeardownStepResult{Name: step.Name, Status: TeardownStepSkipped})
+			continue
+		}
+
+		log.Printf("[%s] %d/%d %s: started", name, i+1, len(steps), step.Name)
+
+		start := time.Now()
+		timedOut, stepErr := runWithTimeout(ctx, step.Run, step.Timeout)
+		result := TeardownStepResult{Name: step.Name, Status: TeardownStepSucceeded, Duration: time.Since(start), Err: stepErr}
+
+		if timedOut {
+			result.Status = TeardownStepTimedOut
+		} else if stepErr != nil {
+			result.Status = TeardownStepFailed
+		}
+
+		if stepErr != nil {
+			log.Printf("[%s] %d/%d %s: %s after %s: %s", name, i+1, len(steps), step.Name, result.Status, result.Duration.Round(time.Millisecond), stepErr)
+			err = errors.Wrapf(stepErr, "Teardown step %s %s", step.Name, result.Status)
+		} else {
+			log.Printf("[%s] %d/%d %s: %s in %s", name, i+1, len(steps), step.Name, result.Status, result.Duration.Round(time.Millisecond))
+		}
+
+		results = append(results, result)
+	}
+
+	return
+}
+
+// contextWithTimeout is context.WithTimeout, a zero timeout has no deadline.
+func contextWithTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// runWithTimeout cancels the context passed to run after the timeout and waits for run to return, so a step never
+// keeps running after it timed out. A zero timeout waits forever.
+func runWithTimeout(parent context.Context, run func(ctx context.Context) error, timeout time.Duration) (timedOut bool, err error) {
+	if timeout <= 0 {
+		return false, run(parent)
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	err = run(ctx)
+
+	if err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		return true, errors.Errorf("timed out after %s", timeout)
+	}
+
+	return false, err
+}
+
+// cleanupInProcess tears down each namespace and its database directly, one at a time over a single database connection.
+// The namespaces left once ctx is done are skipped.
+func cleanupInProcess(ctx context.Context, kubernetesManager external.IKubernetesManager, databaseManager external.IDatabaseManager, config *CleanupConfig, names []string) (err error) {
+	if len(names) == 0 {
+		return
+	}
+
+	connectionString := os.Getenv(external.ConnectionStringEnv)
+	var stepTimeouts *external.StepTimeouts
+	var actionConfigs []external.TeardownActionConfig
+	var backup *external.BackupConfig
+	dropRole := false
+	if config.JobConfig != nil {
+		if config.JobConfig.ConnectionString != "" {
+			connectionString = config.JobConfig.ConnectionString
+		}
+		stepTimeouts = config.JobConfig.StepTimeouts
+		actionConfigs = config.JobConfig.Actions
+		backup = config.JobConfig.Backup
+		dropRole = config.JobConfig.DropRole
+	}
+
+	if connectionString == "" {
+		return errors.Errorf("In process cleanup requires job.connectionString or %s", external.ConnectionStringEnv)
+	}
+
+	timeouts, err := ParseTeardownTimeouts(stepTimeouts)
+	if err != nil {
+		return err
+	}
+
+	actions, err := teardownActionSteps(actionConfigs)
+	if err != nil {
+		return err
+	}
+
+	if err = connectDatabase(databaseManager, connectionString, timeouts.Database); err != nil {
+		return errors.Wrap(err, "Failed to connect to database")
+	}
+	defer databaseManager.Close(context.Background())
+
+	var allErrs []string
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			allErrs = append(allErrs, fmt.Sprintf(" %d) %s: skipped, %s", len(allErrs), name, err))
+			continue
+		}
+
+		if _, err := RunTeardownSteps(ctx, name, teardownSteps(kubernetesManager, databaseManager, name, timeouts, backup, dropRole, actions)); err != nil {
+			allErrs = append(allErrs, fmt.Sprintf(" %d) %s: %s", len(allErrs), name, err))
+		}
+	}
+
+	if len(allErrs) > 0 {
+		allErr := errors.New(strings.Join(allErrs, ""))
+		return errors.Wrap(allErr, "cleanup failed")
+	}
+
+	return
+}
@@ -0,0 +1,146 @@
+package command
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"github.com/pkg/errors"
+)
+
+type TeardownCommand struct {
+	kubernetesManager external.IKubernetesManager
+	databaseManager   external.IDatabaseManager
+	fileReader        external.IFileReader
+	cmd               external.IFlagSet
+
+	Name                 string
+	Kubeconfig           *string
+	Context              *string
+	KubeNamespace        *string
+	ConnectionString     *string
+	ConnectionStringFile *string
+	ScaleDownTimeout     *string
+	NamespaceTimeout     *string
+	DatabaseTimeout      *string
+	BackupTimeout        *string
+	Backup               *string
+	BackupEndpoint       *string
+	BackupRegion         *string
+	BackupInsecure       *bool
+	BackupRetention      *string
+	DropRole             *bool
+	ActionsFile          *string
+	PushGateway          *string
+	Actions              []external.TeardownActionConfig
+}
+
+func NewTeardownCommand(flagProvider external.IFlagProvider, kubernetesManager external.IKubernetesManager, databaseManager external.IDatabaseManager, fileReader external.IFileReader) *TeardownCommand {
+	return &TeardownCommand{
+		kubernetesManager: kubernetesManager,
+		databaseManager:   databaseManager,
+		fileReader:        fileReader,
+		cmd:               flagProvider.NewFlagSet("Teardown", "Scale down, delete the namespace, then drop the database of a branch in order, Usage: Teardown <name> [args]"),
+	}
+}
+
+func (t *TeardownCommand) Names() []string {
+	return []string{"Teardown"}
+}
+
+func (t *TeardownCommand) GetFlags(args []string) (err error) {
+	t.Kubeconfig = t.cmd.String("Kubeconfig", "", "Path to kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config when not running in a cluster")
+	t.Context = t.cmd.String("Context", "", "Kubeconfig context to use instead of the current context")
+	t.KubeNamespace = t.cmd.String("KubeNamespace", "", "Override the namespace of the kubeconfig context")
+	t.ConnectionString = t.cmd.String("ConnectionString", "", "Database connectionString, postgres://, mysql:// or sqlserver://, prefer --ConnectionStringFile or "+external.ConnectionStringEnv+" to keep it out of the process list")
+	t.ConnectionStringFile = t.cmd.String("ConnectionStringFile", "", "Path to a file containing the database connectionString, such as a mounted Secret")
+	t.ScaleDownTimeout = t.cmd.String("ScaleDownTimeout", DefaultTeardownTimeouts.ScaleDown.String(), "Timeout for scaling down the namespace's workloads")
+	t.NamespaceTimeout = t.cmd.String("NamespaceTimeout", DefaultTeardownTimeouts.Namespace.String(), "Timeout for deleting the namespace and waiting for it to terminate")
+	t.DatabaseTimeout = t.cmd.String("DatabaseTimeout", DefaultTeardownTimeouts.Database.String(), "Timeout for connecting to the database, terminating connections and dropping the database, each, also set as the session's lock_timeout and statement_timeout on Postgres")
+	t.BackupTimeout = t.cmd.String("BackupTimeout", DefaultTeardownTimeouts.Backup.String(), "Timeout for backing up the database when --Backup is set")
+	t.Backup = t.cmd.String("Backup", "", "Back up the database before it is dropped to a local directory or s3://bucket/prefix, needs pg_dump")
+	t.BackupEndpoint = t.cmd.String("BackupEndpoint", "", "S3 compatible endpoint for an s3:// --Backup, credentials are read from the AWS_* or MINIO_* env variables")
+	t.BackupRegion = t.cmd.String("BackupRegion", "", "Region of the --Backup bucket")
+	t.BackupInsecure = t.cmd.Bool("BackupInsecure", false, "Use http for the --BackupEndpoint")
+	t.BackupRetention = t.cmd.String("BackupRetention", "", "Number of backups kept per database, older ones are removed, all are kept when not set")
+	t.DropRole = t.cmd.Bool("DropRole", false, "Drop the role named like the database after the database, reassigning what it owns elsewhere to the connecting role. Only a role created by CreateDatabase --CreateRole is dropped")
+	t.ActionsFile = t.cmd.String("ActionsFile", "", "Path to a yaml list of teardown actions to run after the database is dropped, defaults to "+external.TeardownActionsEnv)
+	t.PushGateway = t.cmd.String("PushGateway", "", "Prometheus pushgateway url to push metrics to")
+
+	if err = t.cmd.Parse(args); err != nil {
+		return err
+	}
+
+	if t.cmd.Arg(0) == "" {
+		t.cmd.PrintDefaults()
+		return errors.New("Teardown must have a name")
+	}
+	t.Name = t.cmd.Arg(0)
+
+	if t.ConnectionString, err = resolveConnectionString(t.fileReader, t.ConnectionString, t.ConnectionStringFile); err != nil {
+		return err
+	}
+
+	return t.ReadActions()
+}
+
+// ReadActions reads the teardown actions from --ActionsFile, then the COLLIE_TEARDOWN_ACTIONS env variable.
+func (t *TeardownCommand) ReadActions() (err error) {
+	actions := []byte(os.Getenv(external.TeardownActionsEnv))
+
+	if t.ActionsFile != nil && *t.ActionsFile != "" {
+		if actions, err = t.fileReader.ReadFile(*t.ActionsFile); err != nil {
+			return errors.Wrap(err, "Failed to read ActionsFile")
+		}
+	}
+
+	if len(actions) == 0 {
+		return
+	}
+
+	t.Actions, err = external.ParseTeardownActions(actions)
+	return
+}
+
+func (t *TeardownCommand) Execute() (err error) {
+	defer pushMetrics(t.PushGateway, "collie_teardown")
+
+	timeouts, err := ParseTeardownTimeouts(&external.StepTimeouts{
+		ScaleDown: *t.ScaleDownTimeout,
+		Namespace: *t.NamespaceTimeout,
+		Database:  *t.DatabaseTimeout,
+		Backup:    *t.BackupTimeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	backup, err := backupFlags(t.Backup, t.BackupEndpoint, t.BackupRegion, t.BackupInsecure, t.BackupRetention)
+	if err != nil {
+		return err
+	}
+
+	actions, err := teardownActionSteps(t.Actions)
+	if err != nil {
+		return err
+	}
+
+	if err = connectCluster(t.kubernetesManager, clusterFlags(t.Kubeconfig, t.Context, t.KubeNamespace)); err != nil {
+		return err
+	}
+
+	if err = connectDatabase(t.databaseManager, *t.ConnectionString, timeouts.Database); err != nil {
+		return errors.Wrap(err, "Execute failed to connect")
+	}
+	defer t.databaseManager.Close(context.Background())
+
+	start := time.Now()
+	if _, err = RunTeardownSteps(context.Background(), t.Name, teardownSteps(t.kubernetesManager, t.databaseManager, t.Name, timeouts, backup, t.DropRole != nil && *t.DropRole, actions)); err != nil {
+		return errors.Wrapf(err, "Teardown of %s failed", t.Name)
+	}
+
+	log.Printf("Teardown of %s finished in %s", t.Name, time.Since(start).Round(time.Millisecond))
+	return
+}
@@ -0,0 +1,376 @@
+package command_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/centeva/collie/packages/command"
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
+	"bitbucket.org/centeva/collie/testutils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func teardownTestSetup(mockKubernetesManager *testutils.MockKubernetesManager, mockDatabaseManager *testutils.MockDatabaseManager) *command.TeardownCommand {
+	sut := command.NewTeardownCommand(testutils.NewMockFlagProvider(), mockKubernetesManager, mockDatabaseManager, testutils.NewMockFileReader(""))
+
+	connectionString := "testConnString"
+	kubeconfig := "kubeconfig"
+	empty := ""
+	sut.Name = "test-1"
+	sut.ConnectionString = &connectionString
+	sut.Kubeconfig = &kubeconfig
+	sut.ScaleDownTimeout = &empty
+	sut.NamespaceTimeout = &empty
+	sut.DatabaseTimeout = &empty
+	sut.BackupTimeout = &empty
+
+	return sut
+}
+
+func Test_TeardownExecute(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"test-1"}
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	sut := teardownTestSetup(mockKubernetesManager, mockDatabaseManager)
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	for _, called := range []string{"scaledownworkloads", "deletenamespace", "waitfornamespacedeleted"} {
+		if mockKubernetesManager.Called[called] != 1 {
+			t.Errorf("%s should have been called once, got %v", called, mockKubernetesManager.Called)
+		}
+	}
+
+	for _, called := range []string{"connect", "terminateconnections", "deletedatabase", "close"} {
+		if mockDatabaseManager.Called[called] != 1 {
+			t.Errorf("%s should have been called once, got %v", called, mockDatabaseManager.Called)
+		}
+	}
+
+	args := mockKubernetesManager.CalledWith["waitfornamespacedeleted"][0].(*testutils.KMWaitForNamespaceDeletedArgs)
+	if args.Namespace != "test-1" || args.Timeout != command.DefaultTeardownTimeouts.Namespace {
+		t.Errorf("WaitForNamespaceDeleted() should have been called with test-1 and the default timeout, got %+v", args)
+	}
+	if drop := mockDatabaseManager.CalledWith["deletedatabase"][0].(*testutils.DMDeleteDatabaseArgs); !drop.HasDeadline {
+		t.Errorf("DeleteDatabase() should get the context of its step, got %+v", drop)
+	}
+}
+
+func Test_TeardownMissingDatabase(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.DeleteDatabaseMissing = map[string]bool{"test-1": true}
+	sut := teardownTestSetup(mockKubernetesManager, mockDatabaseManager)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	dropped := testutil.ToFloat64(metrics.DatabasesDropped)
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error for a missing database, got %s", err)
+	}
+
+	if count := testutil.ToFloat64(metrics.DatabasesDropped) - dropped; count != 0 {
+		t.Errorf("Execute() should not count a missing database as dropped, got %v", count)
+	}
+
+	if !strings.Contains(logs.String(), "[test-1] database does not exist") {
+		t.Errorf("Execute() should log that the database does not exist, got %s", logs.String())
+	}
+}
+
+func Test_TeardownExecuteBackup(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.ListDatabasesRes = []string{"test-1"}
+	sut := teardownTestSetup(mockKubernetesManager, mockDatabaseManager)
+
+	backup := t.TempDir()
+	sut.Backup = &backup
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	backups, _ := filepath.Glob(filepath.Join(backup, "test-1", "test-1-*.dump"))
+	if len(backups) != 1 {
+		t.Fatalf("Execute() should back up the database, got %v", backups)
+	}
+
+	if dump, _ := os.ReadFile(backups[0]); string(dump) != "dump of test-1" {
+		t.Errorf("Backup should hold the dump, got %q", dump)
+	}
+}
+
+func Test_TeardownBackupFailureKeepsDatabase(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	mockDatabaseManager.ListDatabasesRes = []string{"test-1"}
+	mockDatabaseManager.BackupDatabaseErr = errors.New("pg_dump: executable file not found")
+	sut := teardownTestSetup(mockKubernetesManager, mockDatabaseManager)
+
+	backup := t.TempDir()
+	sut.Backup = &backup
+
+	if err := sut.Execute(); err == nil {
+		t.Fatalf("Execute() should error when the backup fails")
+	}
+
+	if mockDatabaseManager.Called["deletedatabase"] != 0 {
+		t.Errorf("DeleteDatabase() should not be called when the backup failed")
+	}
+
+	if partial, _ := filepath.Glob(filepath.Join(backup, "test-1", "*")); len(partial) != 0 {
+		t.Errorf("A failed backup should not be kept, got %v", partial)
+	}
+}
+
+func Test_TeardownDropRole(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	sut := teardownTestSetup(mockKubernetesManager, mockDatabaseManager)
+
+	dropRole := true
+	sut.DropRole = &dropRole
+
+	if err := sut.Execute(); err != nil {
+		t.Fatalf("Execute() should not error, got %s", err)
+	}
+
+	if mockDatabaseManager.Called["deletedatabase"] != 1 || mockDatabaseManager.Called["droprole"] != 1 {
+		t.Fatalf("Execute() should drop the database and the role, got %v", mockDatabaseManager.Called)
+	}
+
+	if args := mockDatabaseManager.CalledWith["droprole"][0].(*testutils.DMDropRoleArgs); args.Role != "test-1" {
+		t.Errorf("DropRole() should have been called with test-1, got %+v", args)
+	}
+}
+
+func Test_TeardownStopsAtFailedStep(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.GetNamespacesRes = []string{"test-1"}
+	mockKubernetesManager.WaitForNamespaceDeletedRes = []error{errors.New("namespace stuck terminating")}
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	sut := teardownTestSetup(mockKubernetesManager, mockDatabaseManager)
+
+	if err := sut.Execute(); err == nil {
+		t.Fatalf("Execute() should error when a step fails")
+	}
+
+	if mockDatabaseManager.Called["terminateconnections"] != 0 || mockDatabaseManager.Called["deletedatabase"] != 0 {
+		t.Errorf("Database steps should be skipped after the namespace step fails, got %v", mockDatabaseManager.Called)
+	}
+}
+
+func Test_RunTeardownSteps(t *testing.T) {
+	var order []string
+	step := func(name string, timeout time.Duration, run func(ctx context.Context) error) command.TeardownStep {
+		return command.TeardownStep{Name: name, Timeout: timeout, Run: func(ctx context.Context) error {
+			order = append(order, name)
+			return run(ctx)
+		}}
+	}
+	ok := func(ctx context.Context) error { return nil }
+
+	results, err := command.RunTeardownSteps(context.Background(), "test", []command.TeardownStep{
+		step("first", time.Second, ok),
+		step("second", 10*time.Millisecond, func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+		step("third", time.Second, ok),
+	})
+
+	if err == nil {
+		t.Fatalf("RunTeardownSteps() should error when a step times out")
+	}
+
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("RunTeardownSteps() should run the steps in order and stop after the timeout, got %v", order)
+	}
+
+	want := []command.TeardownStepStatus{command.TeardownStepSucceeded, command.TeardownStepTimedOut, command.TeardownStepSkipped}
+	if len(results) != len(want) {
+		t.Fatalf("RunTeardownSteps() should return a result per step, got %+v", results)
+	}
+
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("Step %s should be %s, got %s", result.Name, want[i], result.Status)
+		}
+	}
+}
+
+func Test_TeardownScaleDownTimeoutStopsStep(t *testing.T) {
+	mockKubernetesManager := testutils.NewMockKubernetesManager()
+	mockKubernetesManager.ScaleDownWorkloadsBlocks = true
+	mockDatabaseManager := testutils.NewMockDatabaseManager()
+	sut := teardownTestSetup(mockKubernetesManager, mockDatabaseManager)
+
+	timeout := "10ms"
+	sut.ScaleDownTimeout = &timeout
+
+	if err := sut.Execute(); err == nil {
+		t.Fatalf("Execute() should error when scale down times out")
+	}
+
+	if mockKubernetesManager.Called["scaledownworkloadsreturned"] != 1 {
+		t.Errorf("Execute() should wait for ScaleDownWorkloads() to stop after the timeout")
+	}
+
+	if args := mockKubernetesManager.CalledWith["scaledownworkloads"][0].(*testutils.KMScaleDownWorkloadsArgs); !args.HasDeadline {
+		t.Errorf("ScaleDownWorkloads() should get the context of its step, got %+v", args)
+	}
+
+	if mockKubernetesManager.Called["deletenamespace"] != 0 {
+		t.Errorf("DeleteNamespace() should not be called after scale down timed out")
+	}
+}
+
+func Test_RunTeardownStepsWaitsForTimedOutStep(t *testing.T) {
+	stopped := false
+
+	results, err := command.RunTeardownSteps(context.Background(), "test", []command.TeardownStep{
+		{Name: "slow", Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(20 * time.Millisecond)
+			stopped = true
+			return ctx.Err()
+		}},
+	})
+
+	if err == nil || results[0].Status != command.TeardownStepTimedOut {
+		t.Fatalf("RunTeardownSteps() should report the step timed out, got %+v %v", results, err)
+	}
+
+	if !stopped {
+		t.Errorf("RunTeardownSteps() should return only once the timed out step stopped")
+	}
+}
+
+func Test_RunTeardownStepsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ran := 0
+	results, err := command.RunTeardownSteps(ctx, "test", []command.TeardownStep{
+		{Name: "first", Timeout: time.Second, Run: func(ctx context.Context) error {
+			ran++
+			return ctx.Err()
+		}},
+		{Name: "second", Timeout: time.Second, Run: func(ctx context.Context) error {
+			ran++
+			return nil
+		}},
+	})
+
+	if err == nil || ran != 1 {
+		t.Fatalf("RunTeardownSteps() should pass the cancelled context to the first step and skip the rest, ran %d got %v", ran, err)
+	}
+
+	if results[0].Status != command.TeardownStepFailed || results[1].Status != command.TeardownStepSkipped {
+		t.Errorf("RunTeardownSteps() should report the cancelled step failed, got %+v", results)
+	}
+}
+
+func Test_TeardownExecuteActionOrder(t *testing.T) {
+	// A helm that fails shows whether the steps after it ran.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "helm"), []byte("#!/bin/sh\necho 'Error: Kubernetes cluster unreachable'\nexit 1\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	tests := []struct {
+		name                string
+		action              external.TeardownActionConfig
+		wantNamespaceDelete int
+	}{
+		{name: "helm runs before the namespace is deleted", action: external.TeardownActionConfig{Type: external.TeardownActionHelm}},
+		{name: "redis runs after the database is dropped", action: external.TeardownActionConfig{Type: external.TeardownActionRedis, Address: "127.0.0.1:1"}, wantNamespaceDelete: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockKubernetesManager := testutils.NewMockKubernetesManager()
+			mockKubernetesManager.GetNamespacesRes = []string{"test-1"}
+			mockDatabaseManager := testutils.NewMockDatabaseManager()
+			sut := teardownTestSetup(mockKubernetesManager, mockDatabaseManager)
+			sut.Actions = []external.TeardownActionConfig{tt.action}
+
+			if err := sut.Execute(); err == nil {
+				t.Fatalf("Execute() should error when the action fails")
+			}
+
+			if mockKubernetesManager.Called["scaledownworkloads"] != 1 {
+				t.Errorf("Execute() should scale down before the actions, got %v", mockKubernetesManager.Called)
+			}
+
+			if mockKubernetesManager.Called["deletenamespace"] != tt.wantNamespaceDelete || mockDatabaseManager.Called["deletedatabase"] != tt.wantNamespaceDelete {
+				t.Errorf("Execute() should delete the namespace and database %d times before the action, got %v %v", tt.wantNamespaceDelete, mockKubernetesManager.Called, mockDatabaseManager.Called)
+			}
+		})
+	}
+}
+
+func Test_TeardownReadActions(t *testing.T) {
+	mockFileReader := testutils.NewMockFileReader("")
+	mockFileReader.Files["actions.yaml"] = []byte(`
+- type: redis
+  address: redis:6379
+- type: helm
+  name: uninstall-api
+  release: "{name}-api"
+  timeout: 2m
+`)
+	sut := command.NewTeardownCommand(testutils.NewMockFlagProvider(), testutils.NewMockKubernetesManager(), testutils.NewMockDatabaseManager(), mockFileReader)
+	actionsFile := "actions.yaml"
+	sut.ActionsFile = &actionsFile
+
+	if err := sut.ReadActions(); err != nil {
+		t.Fatalf("ReadActions() should not error, got %s", err)
+	}
+
+	if len(sut.Actions) != 2 || sut.Actions[0].Type != external.TeardownActionRedis || sut.Actions[1].StepName() != "uninstall-api" {
+		t.Errorf("ReadActions() should read both actions, got %+v", sut.Actions)
+	}
+}
+
+func Test_TeardownReadActionsEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions string
+		wantErr bool
+	}{
+		{name: "valid", actions: "- type: objectStorage\n  address: s3.amazonaws.com\n  bucket: previews\n"},
+		{name: "unknown type", actions: "- type: ftp\n", wantErr: true},
+		{name: "missing setting", actions: "- type: dns\n  zone: example.com\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(external.TeardownActionsEnv, tt.actions)
+			defer os.Unsetenv(external.TeardownActionsEnv)
+
+			sut := command.NewTeardownCommand(testutils.NewMockFlagProvider(), testutils.NewMockKubernetesManager(), testutils.NewMockDatabaseManager(), testutils.NewMockFileReader(""))
+
+			if err := sut.ReadActions(); (err != nil) != tt.wantErr {
+				t.Errorf("ReadActions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
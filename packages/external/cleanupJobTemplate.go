@@ -0,0 +1,356 @@
+package external
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	k8syaml "sigs.k8s.io/yaml"
+)
+
+// CleanupJobOverrides are merged into the cleanup job, use them to meet cluster admission policies
+// without maintaining a full template.
+type CleanupJobOverrides struct {
+	Labels                  map[string]string        `json:"labels,omitempty"`
+	Annotations             map[string]string        `json:"annotations,omitempty"`
+	BackoffLimit            *int32                   `json:"backoffLimit,omitempty"`
+	TTLSecondsAfterFinished *int32                   `json:"ttlSecondsAfterFinished,omitempty"`
+	NodeSelector            map[string]string        `json:"nodeSelector,omitempty"`
+	Tolerations             []v1.Toleration          `json:"tolerations,omitempty"`
+	PriorityClassName       string                   `json:"priorityClassName,omitempty"`
+	PodSecurityContext      *v1.PodSecurityContext   `json:"podSecurityContext,omitempty"`
+	ImagePullPolicy         v1.PullPolicy            `json:"imagePullPolicy,omitempty"`
+	Resources               *v1.ResourceRequirements `json:"resources,omitempty"`
+	SecurityContext         *v1.SecurityContext      `json:"securityContext,omitempty"`
+	Env                     []v1.EnvVar              `json:"env,omitempty"`
+	EnvFrom                 []v1.EnvFromSource       `json:"envFrom,omitempty"`
+}
+
+// UnmarshalYAML decodes through json so the kubernetes types, which only have json tags, are read correctly.
+func (o *CleanupJobOverrides) UnmarshalYAML(value *yaml.Node) (err error) {
+	var raw interface{}
+	if err = value.Decode(&raw); err != nil {
+		return errors.Wrap(err, "Failed to decode job overrides")
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return errors.Wrap(err, "Failed to convert job overrides")
+	}
+
+	type overrides CleanupJobOverrides
+	if err = json.Unmarshal(data, (*overrides)(o)); err != nil {
+		return errors.Wrap(err, "Failed to unmarshal job overrides")
+	}
+
+	return
+}
+
+// ParseJobTemplate reads a Job or PodTemplate manifest used as the base for cleanup jobs.
+func ParseJobTemplate(file []byte) (job *batchv1.Job, err error) {
+	var meta struct {
+		Kind string `json:"kind"`
+	}
+
+	if err = k8syaml.Unmarshal(file, &meta); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal job template")
+	}
+
+	switch meta.Kind {
+	case "", "Job":
+		if err = k8syaml.Unmarshal(file, &job); err != nil {
+			return nil, errors.Wrap(err, "Failed to unmarshal Job template")
+		}
+	case "PodTemplate":
+		var podTemplate *v1.PodTemplate
+		if err = k8syaml.Unmarshal(file, &podTemplate); err != nil {
+			return nil, errors.Wrap(err, "Failed to unmarshal PodTemplate template")
+		}
+		job = &batchv1.Job{}
+		job.Spec.Template = podTemplate.Template
+	default:
+		return nil, errors.Errorf("Job template must be a Job or PodTemplate got '%s'", meta.Kind)
+	}
+
+	if job == nil {
+		return nil, errors.New("Job template is empty")
+	}
+
+	return
+}
+
+const (
+	// ConnectionStringEnv is read by DeleteDatabase and Teardown when --ConnectionString is not set.
+	ConnectionStringEnv = "COLLIE_CONNECTION_STRING"
+	// ConnectionStringKey is the key used in Secrets created for an inline connection string.
+	ConnectionStringKey = "connectionString"
+	// TeardownContainerName is the name of the cleanup job container that runs the teardown steps.
+	TeardownContainerName = "teardown"
+)
+
+// connectionStringSecret returns the Secret the teardown container reads the connection string from, either
+// the configured one or the one CreateCleanupJob creates for an inline connection string.
+func connectionStringSecret(name string, config *CleanupJobConfig) *SecretKeyRef {
+	if config.ConnectionStringSecret != nil {
+		ref := *config.ConnectionStringSecret
+		if ref.Key == "" {
+			ref.Key = ConnectionStringKey
+		}
+		return &ref
+	}
+
+	if config.ConnectionString == "" {
+		return nil
+	}
+
+	return &SecretKeyRef{Name: name, Key: ConnectionStringKey}
+}
+
+// cleanupSecretData returns what the cleanup-<name> Secret holds, an inline connection string and the teardown actions.
+func cleanupSecretData(config *CleanupJobConfig) (data map[string]string, err error) {
+	data = map[string]string{}
+
+	if config.ConnectionStringSecret == nil && config.ConnectionString != "" {
+		data[ConnectionStringKey] = config.ConnectionString
+	}
+
+	if len(config.Actions) > 0 {
+		actions, err := yaml.Marshal(config.Actions)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to marshal teardown actions")
+		}
+
+		data[TeardownActionsKey] = string(actions)
+	}
+
+	return
+}
+
+func secretEnvVar(env string, ref *SecretKeyRef) v1.EnvVar {
+	return v1.EnvVar{
+		Name: env,
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: ref.Name},
+				Key:                  ref.Key,
+			},
+		},
+	}
+}
+
+func teardownArgs(config *CleanupJobConfig) []string {
+	args := []string{"Teardown", config.Name}
+
+	if timeouts := config.StepTimeouts; timeouts != nil {
+		if timeouts.ScaleDown != "" {
+			args = append(args, "--ScaleDownTimeout="+timeouts.ScaleDown)
+		}
+		if timeouts.Namespace != "" {
+			args = append(args, "--NamespaceTimeout="+timeouts.Namespace)
+		}
+		if timeouts.Database != "" {
+			args = append(args, "--DatabaseTimeout="+timeouts.Database)
+		}
+		if timeouts.Backup != "" {
+			args = append(args, "--BackupTimeout="+timeouts.Backup)
+		}
+	}
+
+	if config.DropRole {
+		args = append(args, "--DropRole")
+	}
+
+	if backup := config.Backup; backup != nil {
+		args = append(args, "--Backup="+backup.Destination)
+		if backup.Endpoint != "" {
+			args = append(args, "--BackupEndpoint="+backup.Endpoint)
+		}
+		if backup.Region != "" {
+			args = append(args, "--BackupRegion="+backup.Region)
+		}
+		if backup.Insecure {
+			args = append(args, "--BackupInsecure")
+		}
+		if backup.Retention > 0 {
+			args = append(args, "--BackupRetention="+strconv.Itoa(backup.Retention))
+		}
+	}
+
+	return args
+}
+
+// buildCleanupContainers returns a single container running Teardown, so the steps run in order.
+func buildCleanupContainers(name string, config *CleanupJobConfig) []v1.Container {
+	teardown := v1.Container{
+		Name:  TeardownContainerName,
+		Image: config.Image,
+		Args:  teardownArgs(config),
+	}
+
+	if ref := connectionStringSecret(name, config); ref != nil {
+		teardown.Env = append(teardown.Env, secretEnvVar(ConnectionStringEnv, ref))
+	}
+
+	if len(config.Actions) > 0 {
+		teardown.Env = append(teardown.Env, secretEnvVar(TeardownActionsEnv, &SecretKeyRef{Name: name, Key: TeardownActionsKey}))
+	}
+
+	return []v1.Container{teardown}
+}
+
+// buildCleanupJob merges the cleanup containers and overrides into the template. Template containers with
+// the same name as a cleanup container are used as its base, any other template containers are kept.
+func buildCleanupJob(name string, config *CleanupJobConfig) *batchv1.Job {
+	job := &batchv1.Job{}
+	if config.Template != nil {
+		job = config.Template.DeepCopy()
+	}
+
+	job.TypeMeta = batchv1.Job{}.TypeMeta
+	job.Name = name
+	job.Namespace = config.JobNamespace
+	job.ResourceVersion = ""
+	job.UID = ""
+
+	spec := &job.Spec.Template.Spec
+
+	if job.Spec.TTLSecondsAfterFinished == nil {
+		// Successful jobs are deleted by CreateCleanupJob, keep failed jobs around long enough to read their logs.
+		ttlSecondsAfterFinished := int32(60)
+		job.Spec.TTLSecondsAfterFinished = &ttlSecondsAfterFinished
+	}
+
+	if spec.RestartPolicy == "" {
+		spec.RestartPolicy = v1.RestartPolicyNever
+	}
+
+	if config.ServiceAccount != "" {
+		spec.ServiceAccountName = config.ServiceAccount
+	}
+
+	if config.ImagePullSecret != "" && !hasImagePullSecret(spec.ImagePullSecrets, config.ImagePullSecret) {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, v1.LocalObjectReference{Name: config.ImagePullSecret})
+	}
+
+	var containers []v1.Container
+	for _, container := range buildCleanupContainers(name, config) {
+		if base := findContainer(spec.Containers, container.Name); base != nil {
+			base.Args = container.Args
+			base.Env = append(base.Env, container.Env...)
+			if base.Image == "" {
+				base.Image = container.Image
+			}
+			container = *base
+		}
+
+		if container.ImagePullPolicy == "" {
+			container.ImagePullPolicy = v1.PullAlways
+		}
+
+		containers = append(containers, container)
+	}
+
+	for _, container := range spec.Containers {
+		if findContainer(containers, container.Name) == nil {
+			containers = append(containers, container)
+		}
+	}
+
+	spec.Containers = containers
+
+	applyOverrides(job, config.Overrides)
+	return job
+}
+
+func applyOverrides(job *batchv1.Job, overrides *CleanupJobOverrides) {
+	if overrides == nil {
+		return
+	}
+
+	spec := &job.Spec.Template.Spec
+
+	job.Labels = mergeMap(job.Labels, overrides.Labels)
+	job.Spec.Template.Labels = mergeMap(job.Spec.Template.Labels, overrides.Labels)
+	job.Annotations = mergeMap(job.Annotations, overrides.Annotations)
+	job.Spec.Template.Annotations = mergeMap(job.Spec.Template.Annotations, overrides.Annotations)
+	spec.NodeSelector = mergeMap(spec.NodeSelector, overrides.NodeSelector)
+	spec.Tolerations = append(spec.Tolerations, overrides.Tolerations...)
+
+	if overrides.BackoffLimit != nil {
+		job.Spec.BackoffLimit = overrides.BackoffLimit
+	}
+
+	if overrides.TTLSecondsAfterFinished != nil {
+		job.Spec.TTLSecondsAfterFinished = overrides.TTLSecondsAfterFinished
+	}
+
+	if overrides.PriorityClassName != "" {
+		spec.PriorityClassName = overrides.PriorityClassName
+	}
+
+	if overrides.PodSecurityContext != nil {
+		spec.SecurityContext = overrides.PodSecurityContext
+	}
+
+	for i := range spec.Containers {
+		container := &spec.Containers[i]
+
+		if container.Name != TeardownContainerName {
+			continue
+		}
+
+		if overrides.ImagePullPolicy != "" {
+			container.ImagePullPolicy = overrides.ImagePullPolicy
+		}
+
+		if overrides.Resources != nil {
+			container.Resources = *overrides.Resources
+		}
+
+		if overrides.SecurityContext != nil {
+			container.SecurityContext = overrides.SecurityContext
+		}
+
+		container.Env = append(container.Env, overrides.Env...)
+		container.EnvFrom = append(container.EnvFrom, overrides.EnvFrom...)
+	}
+}
+
+func findContainer(containers []v1.Container, name string) *v1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+
+	return nil
+}
+
+func hasImagePullSecret(secrets []v1.LocalObjectReference, name string) bool {
+	for _, secret := range secrets {
+		if secret.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mergeMap(base map[string]string, overrides map[string]string) map[string]string {
+	if len(overrides) == 0 {
+		return base
+	}
+
+	if base == nil {
+		base = make(map[string]string)
+	}
+
+	for key, value := range overrides {
+		base[key] = value
+	}
+
+	return base
+}
@@ -0,0 +1,240 @@
+package external
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+const (
+	backupExtension       = ".dump"
+	backupTimestampFormat = "20060102T150405Z"
+)
+
+// BackupConfig configures the backup taken before a database is dropped. Destination is a local directory or
+// s3://bucket/prefix, S3 credentials are read from the AWS_* or MINIO_* env variables, then the IAM role.
+type BackupConfig struct {
+	Destination string `yaml:"destination"`
+	Endpoint    string `yaml:"endpoint,omitempty"`
+	Region      string `yaml:"region,omitempty"`
+	Insecure    bool   `yaml:"insecure,omitempty"`
+	// Retention is the number of backups kept per database, 0 keeps all of them.
+	Retention int `yaml:"retention,omitempty"`
+}
+
+// IBackupStore keeps database backups as <database>/<database>-<timestamp>.dump under its destination.
+type IBackupStore interface {
+	Save(ctx context.Context, database string, dump func(w io.Writer) error) (location string, err error)
+	Prune(ctx context.Context, database string, keep int) (removed []string, err error)
+}
+
+// NewBackupStore returns the store for the destination of the config.
+func NewBackupStore(config BackupConfig) (store IBackupStore, err error) {
+	if config.Destination == "" {
+		return nil, errors.New("Backup destination is required")
+	}
+
+	if config.Retention < 0 {
+		return nil, errors.Errorf("Backup retention must not be negative, got %d", config.Retention)
+	}
+
+	if !strings.HasPrefix(config.Destination, "s3://") {
+		return &localBackupStore{directory: config.Destination}, nil
+	}
+
+	destination, err := url.Parse(config.Destination)
+	if err != nil || destination.Host == "" {
+		return nil, errors.Errorf("Backup destination '%s' must be s3://bucket/prefix", config.Destination)
+	}
+
+	if config.Endpoint == "" {
+		return nil, errors.New("Backup endpoint is required for an s3 destination")
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		}),
+		Secure: !config.Insecure,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create backup storage client")
+	}
+
+	return &s3BackupStore{
+		client: client,
+		bucket: destination.Host,
+		prefix: strings.Trim(destination.Path, "/"),
+	}, nil
+}
+
+func backupName(database string, at time.Time) string {
+	return database + "-" + at.UTC().Format(backupTimestampFormat) + backupExtension
+}
+
+// expiredBackups returns the names of the database's backups beyond the newest keep, names sort by timestamp.
+func expiredBackups(database string, names []string, keep int) (expired []string) {
+	var backups []string
+	for _, name := range names {
+		if strings.HasPrefix(name, database+"-") && strings.HasSuffix(name, backupExtension) {
+			backups = append(backups, name)
+		}
+	}
+
+	if keep <= 0 || len(backups) <= keep {
+		return nil
+	}
+
+	sort.Strings(backups)
+	return backups[:len(backups)-keep]
+}
+
+type localBackupStore struct {
+	directory string
+}
+
+func (s *localBackupStore) Save(ctx context.Context, database string, dump func(w io.Writer) error) (location string, err error) {
+	directory := filepath.Join(s.directory, database)
+
+	if err = os.MkdirAll(directory, 0o700); err != nil {
+		return "", errors.Wrapf(err, "Failed to create backup directory %s", directory)
+	}
+
+	location = filepath.Join(directory, backupName(database, time.Now()))
+	partial := location + ".partial"
+
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to create backup %s", partial)
+	}
+
+	err = dump(file)
+
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = errors.Wrapf(closeErr, "Failed to write backup %s", partial)
+	}
+
+	if err == nil {
+		if err = os.Rename(partial, location); err != nil {
+			err = errors.Wrapf(err, "Failed to write backup %s", location)
+		}
+	}
+
+	if err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+
+	return location, nil
+}
+
+func (s *localBackupStore) Prune(ctx context.Context, database string, keep int) (removed []string, err error) {
+	directory := filepath.Join(s.directory, database)
+	entries, err := os.ReadDir(directory)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list backups in %s", directory)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	for _, name := range expiredBackups(database, names, keep) {
+		location := filepath.Join(directory, name)
+
+		if err = os.Remove(location); err != nil {
+			return removed, errors.Wrapf(err, "Failed to remove backup %s", location)
+		}
+
+		removed = append(removed, location)
+	}
+
+	return
+}
+
+type s3BackupStore struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3BackupStore) directory(database string) string {
+	return path.Join(s.prefix, database) + "/"
+}
+
+func (s *s3BackupStore) location(key string) string {
+	return "s3://" + s.bucket + "/" + key
+}
+
+// Save streams the dump to a multipart upload, a failed dump aborts the upload.
+func (s *s3BackupStore) Save(ctx context.Context, database string, dump func(w io.Writer) error) (location string, err error) {
+	key := s.directory(database) + backupName(database, time.Now())
+	reader, writer := io.Pipe()
+	dumpErr := make(chan error, 1)
+
+	go func() {
+		err := dump(writer)
+		writer.CloseWithError(err)
+		dumpErr <- err
+	}()
+
+	_, err = s.client.PutObject(ctx, s.bucket, key, reader, -1, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	// Unblocks the dump when the upload stopped reading early.
+	reader.CloseWithError(io.ErrClosedPipe)
+	dumpFailed := <-dumpErr
+
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to upload backup %s", s.location(key))
+	}
+
+	if dumpFailed != nil {
+		return "", dumpFailed
+	}
+
+	return s.location(key), nil
+}
+
+func (s *s3BackupStore) Prune(ctx context.Context, database string, keep int) (removed []string, err error) {
+	directory := s.directory(database)
+
+	var names []string
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: directory}) {
+		if object.Err != nil {
+			return nil, errors.Wrapf(object.Err, "Failed to list backups in %s", s.location(directory))
+		}
+
+		names = append(names, strings.TrimPrefix(object.Key, directory))
+	}
+
+	for _, name := range expiredBackups(database, names, keep) {
+		key := directory + name
+
+		if err = s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return removed, errors.Wrapf(err, "Failed to remove backup %s", s.location(key))
+		}
+
+		removed = append(removed, s.location(key))
+	}
+
+	return
+}
@@ -0,0 +1,32 @@
+package external_test
+
+import (
+	"testing"
+
+	"bitbucket.org/centeva/collie/packages/external"
+)
+
+func Test_NewBackupStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  external.BackupConfig
+		wantErr bool
+	}{
+		{name: "local", config: external.BackupConfig{Destination: "/backups", Retention: 3}},
+		{name: "s3", config: external.BackupConfig{Destination: "s3://backups/previews", Endpoint: "minio:9000"}},
+		{name: "no destination", config: external.BackupConfig{}, wantErr: true},
+		{name: "negative retention", config: external.BackupConfig{Destination: "/backups", Retention: -1}, wantErr: true},
+		{name: "s3 without endpoint", config: external.BackupConfig{Destination: "s3://backups"}, wantErr: true},
+		{name: "s3 without bucket", config: external.BackupConfig{Destination: "s3:///previews", Endpoint: "minio:9000"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := external.NewBackupStore(tt.config)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewBackupStore() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
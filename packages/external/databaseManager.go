@@ -0,0 +1,114 @@
+package external
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidDatabaseName is the cause of the error returned for a database name that doesn't match databaseNamePattern.
+var ErrInvalidDatabaseName = errors.New("invalid database name")
+
+// ErrNotSupported is the cause of the error returned for an operation the database server type doesn't support.
+var ErrNotSupported = errors.New("not supported")
+
+// databaseNamePattern allows the names CleanBranch produces plus underscores, within the 63 byte identifier limit.
+var databaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]{0,62}$`)
+
+// IDatabaseManager drops and creates the databases of preview environments on a database server.
+type IDatabaseManager interface {
+	Connect(ctx context.Context, connectionString string) (err error)
+	TerminateConnections(ctx context.Context, database string) (err error)
+	DeleteDatabase(ctx context.Context, database string) (dropped bool, err error)
+	ListDatabases(ctx context.Context) (databases []string, err error)
+	CreateDatabase(ctx context.Context, database string, options CreateDatabaseOptions) (created bool, err error)
+	CreateRole(ctx context.Context, role string, password string) (created bool, err error)
+	DropRole(ctx context.Context, role string) (err error)
+	BackupDatabase(ctx context.Context, database string, w io.Writer) (err error)
+	// Close is safe to call when Connect failed or was never called.
+	Close(ctx context.Context)
+}
+
+// CreateDatabaseOptions are the optional clauses of CREATE DATABASE.
+type CreateDatabaseOptions struct {
+	// Template is the database to copy, it must not have other connections while it is copied.
+	Template string
+	Owner    string
+}
+
+// DatabaseManager connects to the server type of the connection string and passes every call on to its manager:
+// mysql:// and mariadb:// (followed by a go-sql-driver DSN) use the MySQLManager, sqlserver:// the SQLServerManager,
+// and anything else, such as postgres:// or a key=value connection string, the PostgresManager.
+type DatabaseManager struct {
+	IDatabaseManager
+}
+
+func NewDatabaseManager() *DatabaseManager {
+	return &DatabaseManager{}
+}
+
+// Connect replaces the manager with one for the server type of the connection string.
+func (d *DatabaseManager) Connect(ctx context.Context, connectionString string) (err error) {
+	manager := NewDatabaseManagerFor(connectionString)
+
+	if err = manager.Connect(ctx, connectionString); err != nil {
+		return err
+	}
+
+	d.IDatabaseManager = manager
+	return
+}
+
+func (d *DatabaseManager) Close(ctx context.Context) {
+	if d.IDatabaseManager != nil {
+		d.IDatabaseManager.Close(ctx)
+	}
+}
+
+// NewDatabaseManagerFor returns an unconnected manager for the server type of the connection string.
+func NewDatabaseManagerFor(connectionString string) IDatabaseManager {
+	switch {
+	case strings.HasPrefix(connectionString, "mysql://"), strings.HasPrefix(connectionString, "mariadb://"):
+		return NewMySQLManager()
+	case strings.HasPrefix(connectionString, "sqlserver://"):
+		return NewSQLServerManager()
+	default:
+		return NewPostgresManager()
+	}
+}
+
+// ValidateDatabaseName returns an error with the cause ErrInvalidDatabaseName when the name is not a plain identifier.
+func ValidateDatabaseName(database string) error {
+	if !databaseNamePattern.MatchString(database) {
+		return errors.Wrapf(ErrInvalidDatabaseName, "'%s' must match %s", database, databaseNamePattern)
+	}
+
+	return nil
+}
+
+// queryStrings returns the first column of every row of a database/sql query.
+func queryStrings(ctx context.Context, db *sql.DB, query string, arguments ...interface{}) (values []string, err error) {
+	rows, err := db.QueryContext(ctx, query, arguments...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func notSupported(operation string, server string) error {
+	return errors.Wrapf(ErrNotSupported, "%s is not supported for %s", operation, server)
+}
@@ -0,0 +1,64 @@
+package external
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// dnsTeardownAction deletes every record set of a name with an RFC 2136 dynamic update, supported by BIND,
+// PowerDNS, Knot and Windows DNS among others.
+type dnsTeardownAction struct {
+	config TeardownActionConfig
+}
+
+func newDNSTeardownAction(config TeardownActionConfig) (*dnsTeardownAction, error) {
+	if err := requireSettings(map[string]string{"address": config.Address, "zone": config.Zone, "record": config.Record}); err != nil {
+		return nil, err
+	}
+
+	if (config.TSIGKey == "") != (config.TSIGSecret == "") {
+		return nil, errors.New("tsigKey and tsigSecret must be set together")
+	}
+
+	return &dnsTeardownAction{config: config}, nil
+}
+
+func (a *dnsTeardownAction) Teardown(ctx context.Context, name string) (err error) {
+	zone := dns.Fqdn(a.config.Zone)
+	record := dns.Fqdn(expandName(a.config.Record, "", name))
+
+	if !dns.IsSubDomain(zone, record) {
+		return errors.Errorf("Record %s is not in zone %s", record, zone)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(zone)
+	msg.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: record, Rrtype: dns.TypeANY, Class: dns.ClassANY}}})
+
+	client := new(dns.Client)
+
+	if a.config.TSIGKey != "" {
+		key := dns.Fqdn(a.config.TSIGKey)
+		algorithm := dns.Fqdn(strings.ToLower(a.config.TSIGAlgorithm))
+		if a.config.TSIGAlgorithm == "" {
+			algorithm = dns.HmacSHA256
+		}
+
+		client.TsigSecret = map[string]string{key: a.config.TSIGSecret}
+		msg.SetTsig(key, algorithm, 300, 0)
+	}
+
+	res, _, err := client.ExchangeContext(ctx, msg, a.config.Address)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to delete dns record %s", record)
+	}
+
+	if res.Rcode != dns.RcodeSuccess {
+		return errors.Errorf("Failed to delete dns record %s: %s", record, dns.RcodeToString[res.Rcode])
+	}
+
+	return
+}
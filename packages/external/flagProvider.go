@@ -16,6 +16,7 @@ type IFlagProvider interface {
 
 type FlagProvider struct {
 	usageLookup map[string]string
+	config      *flagConfig
 }
 
 func NewFlagProvider() *FlagProvider {
@@ -44,11 +45,15 @@ func (f *FlagProvider) PrintDefaults() {
 	flag.PrintDefaults()
 }
 
+// IFlagSet parses the flags of a command, Parse returns flag.ErrHelp after printing the usage for -h or --help.
 type IFlagSet interface {
 	String(name string, value string, usage string) *string
+	Bool(name string, value bool, usage string) *bool
 	StringVar(p *string, name string, value string, usage string)
 	Parse(arguments []string) error
 	Arg(i int) string
+	// Args are the positional arguments, which may come before, between or after the flags.
+	Args() []string
 	PrintDefaults()
 }
 
@@ -57,7 +62,7 @@ type IUsage interface {
 }
 
 func (f *FlagProvider) NewFlagSet(name string, usage string) IFlagSet {
-	cmd := flag.NewFlagSet(name, flag.ExitOnError)
+	cmd := flag.NewFlagSet(name, flag.ContinueOnError)
 
 	cmd.Usage = func() {
 		log.Printf("Usage of %s:\n", name)
@@ -67,5 +72,57 @@ func (f *FlagProvider) NewFlagSet(name string, usage string) IFlagSet {
 
 	f.usageLookup[name] = usage
 
-	return cmd
+	return &FlagSet{FlagSet: cmd, provider: f}
+}
+
+// FlagSet is a flag.FlagSet that keeps parsing flags after a positional argument, until --. Flags not set on the
+// command line are read from the environment, then from the config file, see FlagEnv and ConfigEnv.
+type FlagSet struct {
+	*flag.FlagSet
+	provider *FlagProvider
+	args     []string
+}
+
+func (f *FlagSet) Parse(arguments []string) (err error) {
+	if err = f.parseArguments(arguments); err != nil {
+		return err
+	}
+
+	return f.applySources()
+}
+
+func (f *FlagSet) parseArguments(arguments []string) (err error) {
+	f.args = nil
+
+	for {
+		if err = f.FlagSet.Parse(arguments); err != nil {
+			return err
+		}
+
+		rest := f.FlagSet.Args()
+		if len(rest) == 0 {
+			return nil
+		}
+
+		// Everything after -- is positional.
+		if consumed := len(arguments) - len(rest); consumed > 0 && arguments[consumed-1] == "--" {
+			f.args = append(f.args, rest...)
+			return nil
+		}
+
+		f.args = append(f.args, rest[0])
+		arguments = rest[1:]
+	}
+}
+
+func (f *FlagSet) Args() []string {
+	return f.args
+}
+
+func (f *FlagSet) Arg(i int) string {
+	if i < 0 || i >= len(f.args) {
+		return ""
+	}
+
+	return f.args[i]
 }
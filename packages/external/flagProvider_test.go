@@ -0,0 +1,196 @@
+package external_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"bitbucket.org/centeva/collie/packages/external"
+)
+
+func Test_FlagSetParseInterspersed(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantArgs []string
+		wantTag  string
+		wantDry  bool
+	}{
+		{name: "positional first", args: []string{"pr-1", "--Tag", "v1", "--DryRun"}, wantArgs: []string{"pr-1"}, wantTag: "v1", wantDry: true},
+		{name: "positional last", args: []string{"--Tag=v1", "--DryRun", "pr-1"}, wantArgs: []string{"pr-1"}, wantTag: "v1", wantDry: true},
+		{name: "positional between", args: []string{"pr-1", "--DryRun", "pr-2", "--Tag", "v1", "pr-3"}, wantArgs: []string{"pr-1", "pr-2", "pr-3"}, wantTag: "v1", wantDry: true},
+		{name: "after --", args: []string{"--Tag", "v1", "--", "--DryRun", "pr-1"}, wantArgs: []string{"--DryRun", "pr-1"}, wantTag: "v1"},
+		{name: "no positional", args: []string{"--Tag", "v1"}, wantTag: "v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut := external.NewFlagProvider().NewFlagSet("Test", "Test usage")
+			tag := sut.String("Tag", "", "tag")
+			dryRun := sut.Bool("DryRun", false, "dry run")
+
+			if err := sut.Parse(tt.args); err != nil {
+				t.Fatalf("Parse() should not error, got %s", err)
+			}
+
+			if !reflect.DeepEqual(sut.Args(), tt.wantArgs) {
+				t.Errorf("Args() should be %v, got %v", tt.wantArgs, sut.Args())
+			}
+
+			if *tag != tt.wantTag || *dryRun != tt.wantDry {
+				t.Errorf("Parse() should set Tag %s and DryRun %t, got %s %t", tt.wantTag, tt.wantDry, *tag, *dryRun)
+			}
+		})
+	}
+}
+
+func Test_FlagSetParseHelp(t *testing.T) {
+	sut := external.NewFlagProvider().NewFlagSet("Test", "Test usage")
+	sut.String("Tag", "", "tag")
+
+	if err := sut.Parse([]string{"pr-1", "--help"}); err != flag.ErrHelp {
+		t.Errorf("Parse() should return flag.ErrHelp for --help, got %v", err)
+	}
+}
+
+func Test_FlagSetParseUnknownFlag(t *testing.T) {
+	sut := external.NewFlagProvider().NewFlagSet("Test", "Test usage")
+
+	if err := sut.Parse([]string{"pr-1", "--Unknown"}); err == nil {
+		t.Errorf("Parse() should error on an unknown flag instead of exiting")
+	}
+}
+
+func Test_FlagEnv(t *testing.T) {
+	tests := []struct {
+		command string
+		name    string
+		want    string
+	}{
+		{command: "DeleteDatabase", name: "ConnectionString", want: "COLLIE_DELETE_DATABASE_CONNECTION_STRING"},
+		{command: "CleanBranch", name: "Secret", want: "COLLIE_CLEAN_BRANCH_SECRET"},
+		{command: "Comment", name: "ClientId", want: "COLLIE_COMMENT_CLIENT_ID"},
+		{command: "Serve", name: "HTTPAddress", want: "COLLIE_SERVE_HTTP_ADDRESS"},
+	}
+
+	for _, tt := range tests {
+		if got := external.FlagEnv(tt.command, tt.name); got != tt.want {
+			t.Errorf("FlagEnv(%s, %s) should be %s, got %s", tt.command, tt.name, tt.want, got)
+		}
+	}
+}
+
+func writeFlagConfig(t *testing.T, content string) {
+	path := filepath.Join(t.TempDir(), "collie.yaml")
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv(external.ConfigEnv, path)
+	t.Cleanup(func() { os.Unsetenv(external.ConfigEnv) })
+}
+
+func setFlagEnv(t *testing.T, name string, value string) {
+	os.Setenv(name, value)
+	t.Cleanup(func() { os.Unsetenv(name) })
+}
+
+func Test_FlagSetParseSources(t *testing.T) {
+	writeFlagConfig(t, `
+Token: shared-token
+Repo: shared-repo
+Comment:
+  Token: comment-token
+  Owner: config-owner
+  DryRun: true
+  Retries: 3
+`)
+	setFlagEnv(t, "COLLIE_COMMENT_OWNER", "env-owner")
+	setFlagEnv(t, "COLLIE_COMMENT_WORKSPACE", "env-workspace")
+
+	sut := external.NewFlagProvider().NewFlagSet("Comment", "Test usage")
+	token := sut.String("Token", "", "token")
+	repo := sut.String("Repo", "", "repo")
+	owner := sut.String("Owner", "", "owner")
+	workspace := sut.String("Workspace", "", "workspace")
+	retries := sut.String("Retries", "1", "retries")
+	dryRun := sut.Bool("DryRun", false, "dry run")
+	other := sut.String("Other", "default", "other")
+
+	if err := sut.Parse([]string{"pr-1", "--Workspace", "flag-workspace"}); err != nil {
+		t.Fatalf("Parse() should not error, got %s", err)
+	}
+
+	got := map[string]string{
+		"Token":     *token,
+		"Repo":      *repo,
+		"Owner":     *owner,
+		"Workspace": *workspace,
+		"Retries":   *retries,
+		"Other":     *other,
+	}
+	want := map[string]string{
+		"Token":     "comment-token",
+		"Repo":      "shared-repo",
+		"Owner":     "env-owner",
+		"Workspace": "flag-workspace",
+		"Retries":   "3",
+		"Other":     "default",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() should prefer flag > env > file > default, want %v, got %v", want, got)
+	}
+
+	if !*dryRun {
+		t.Errorf("Parse() should set DryRun from the config file")
+	}
+}
+
+func Test_FlagSetParseSourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		env    string
+	}{
+		{name: "unknown flag in section", config: "Test:\n  Unknown: value\n"},
+		{name: "list value", config: "Tag: [a, b]\n"},
+		{name: "invalid bool in file", config: "Test:\n  DryRun: maybe\n"},
+		{name: "invalid bool in env", env: "maybe"},
+		{name: "invalid yaml", config: "Test: [\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.config != "" {
+				writeFlagConfig(t, tt.config)
+			}
+
+			if tt.env != "" {
+				setFlagEnv(t, "COLLIE_TEST_DRY_RUN", tt.env)
+			}
+
+			sut := external.NewFlagProvider().NewFlagSet("Test", "Test usage")
+			sut.String("Tag", "", "tag")
+			sut.Bool("DryRun", false, "dry run")
+
+			if err := sut.Parse(nil); err == nil {
+				t.Errorf("Parse() should error")
+			}
+		})
+	}
+}
+
+func Test_FlagSetParseMissingConfig(t *testing.T) {
+	os.Setenv(external.ConfigEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	defer os.Unsetenv(external.ConfigEnv)
+
+	sut := external.NewFlagProvider().NewFlagSet("Test", "Test usage")
+
+	if err := sut.Parse(nil); err == nil {
+		t.Errorf("Parse() should error when the config file can't be read")
+	}
+}
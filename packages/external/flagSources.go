@@ -0,0 +1,205 @@
+package external
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigEnv is the path of a yaml file with flag values shared by every command. Top level values apply to each
+	// command with that flag, a section named after a command sets the flags of that command only, for example
+	//
+	//	ConnectionString: postgres://collie@db/postgres
+	//	Comment:
+	//	  Token: ghp_...
+	ConfigEnv = "COLLIE_CONFIG"
+	// flagEnvPrefix starts the env variable of a flag, COLLIE_<COMMAND>_<FLAG>, see FlagEnv.
+	flagEnvPrefix = "COLLIE_"
+)
+
+// FlagEnv is the env variable read for a flag of a command that is not set on the command line, such as
+// COLLIE_DELETE_DATABASE_CONNECTION_STRING for the ConnectionString flag of DeleteDatabase.
+func FlagEnv(command string, name string) string {
+	return flagEnvPrefix + envName(command) + "_" + envName(name)
+}
+
+// envName converts a CamelCase name to UPPER_SNAKE_CASE, ConnectionString becomes CONNECTION_STRING.
+func envName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			b.WriteRune('_')
+			continue
+		}
+
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+
+		b.WriteRune(unicode.ToUpper(r))
+	}
+
+	return b.String()
+}
+
+// flagConfig is the config file of ConfigEnv, read once by the first FlagSet that parses.
+type flagConfig struct {
+	path   string
+	values map[string]interface{}
+}
+
+// loadConfig reads the file of ConfigEnv, there is no config when it is not set.
+func (f *FlagProvider) loadConfig() (config *flagConfig, err error) {
+	if f.config != nil {
+		return f.config, nil
+	}
+
+	config = &flagConfig{path: os.Getenv(ConfigEnv)}
+
+	if config.path != "" {
+		data, err := os.ReadFile(config.path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read config file %s", config.path)
+		}
+
+		if err = yaml.Unmarshal(data, &config.values); err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse config file %s", config.path)
+		}
+	}
+
+	f.config = config
+	return
+}
+
+// lookup returns the value of a flag for the command, the command's section wins over the top level.
+func (c *flagConfig) lookup(command string, name string) (value string, ok bool, err error) {
+	for key, section := range c.values {
+		values, isSection := section.(map[string]interface{})
+
+		if !isSection || !strings.EqualFold(key, command) {
+			continue
+		}
+
+		if value, ok, err = configValue(values, command+"."+name, name); ok || err != nil {
+			return
+		}
+	}
+
+	return configValue(c.values, name, name)
+}
+
+// unknownFlags returns the keys of the command's section that are not flags of the command.
+func (c *flagConfig) unknownFlags(cmd *flag.FlagSet) (unknown []string) {
+	for key, section := range c.values {
+		values, isSection := section.(map[string]interface{})
+
+		if !isSection || !strings.EqualFold(key, cmd.Name()) {
+			continue
+		}
+
+		for name := range values {
+			if lookupFlag(cmd, name) == nil {
+				unknown = append(unknown, key+"."+name)
+			}
+		}
+	}
+
+	sort.Strings(unknown)
+	return
+}
+
+func configValue(values map[string]interface{}, path string, name string) (value string, ok bool, err error) {
+	for key, raw := range values {
+		if !strings.EqualFold(key, name) {
+			continue
+		}
+
+		switch raw.(type) {
+		case map[string]interface{}:
+			// A top level key naming a command is that command's section.
+			continue
+		case []interface{}:
+			return "", false, errors.Errorf("Config value %s must be a scalar", path)
+		case nil:
+			return "", false, nil
+		}
+
+		return fmt.Sprint(raw), true, nil
+	}
+
+	return "", false, nil
+}
+
+func lookupFlag(cmd *flag.FlagSet, name string) (found *flag.Flag) {
+	cmd.VisitAll(func(f *flag.Flag) {
+		if strings.EqualFold(f.Name, name) {
+			found = f
+		}
+	})
+
+	return
+}
+
+// applySources sets the flags missing from the command line from their env variable, then from the config file.
+func (f *FlagSet) applySources() (err error) {
+	config := &flagConfig{}
+	if f.provider != nil {
+		if config, err = f.provider.loadConfig(); err != nil {
+			return err
+		}
+	}
+
+	if unknown := config.unknownFlags(f.FlagSet); len(unknown) > 0 {
+		return errors.Errorf("Config file %s sets unknown flags %s", config.path, strings.Join(unknown, ", "))
+	}
+
+	set := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) {
+		set[fl.Name] = true
+	})
+
+	var missing []*flag.Flag
+	f.VisitAll(func(fl *flag.Flag) {
+		if !set[fl.Name] {
+			missing = append(missing, fl)
+		}
+	})
+
+	for _, fl := range missing {
+		env := FlagEnv(f.Name(), fl.Name)
+
+		if value, ok := os.LookupEnv(env); ok {
+			if err = f.Set(fl.Name, value); err != nil {
+				return errors.Wrapf(err, "Invalid value for %s from %s", fl.Name, env)
+			}
+			continue
+		}
+
+		value, ok, err := config.lookup(f.Name(), fl.Name)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid config file %s", config.path)
+		}
+
+		if ok {
+			if err = f.Set(fl.Name, value); err != nil {
+				return errors.Wrapf(err, "Invalid value for %s from config file %s", fl.Name, config.path)
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,40 @@
+package external
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// helmTeardownAction uninstalls a Helm release, release and namespace default to {name}. It runs the helm
+// binary, which has to be on the PATH of the image running the teardown.
+type helmTeardownAction struct {
+	config TeardownActionConfig
+}
+
+func newHelmTeardownAction(config TeardownActionConfig) (*helmTeardownAction, error) {
+	return &helmTeardownAction{config: config}, nil
+}
+
+func (a *helmTeardownAction) Teardown(ctx context.Context, name string) (err error) {
+	release := expandName(a.config.Release, NamePlaceholder, name)
+	namespace := expandName(a.config.Namespace, NamePlaceholder, name)
+
+	output, err := exec.CommandContext(ctx, "helm", "uninstall", release, "--namespace", namespace).CombinedOutput()
+
+	if err != nil {
+		// The release was never installed or is already uninstalled, this runs before the namespace holding it is
+		// deleted so it isn't missing because the teardown removed it.
+		if strings.Contains(string(output), "release: not found") {
+			log.Printf("Helm release %s not found in %s", release, namespace)
+			return nil
+		}
+
+		return errors.Wrapf(err, "Failed to uninstall helm release %s: %s", release, strings.TrimSpace(string(output)))
+	}
+
+	return
+}
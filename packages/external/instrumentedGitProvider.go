@@ -0,0 +1,49 @@
+package external
+
+import (
+	"time"
+
+	"bitbucket.org/centeva/collie/packages/metrics"
+)
+
+// InstrumentedGitProvider records latency and error metrics for every call to the wrapped provider.
+type InstrumentedGitProvider struct {
+	provider IGitProvider
+	name     string
+}
+
+func NewInstrumentedGitProvider(name string, provider IGitProvider) *InstrumentedGitProvider {
+	return &InstrumentedGitProvider{
+		provider: provider,
+		name:     name,
+	}
+}
+
+func (i *InstrumentedGitProvider) observe(operation string, start time.Time, err error) {
+	metrics.GitProviderRequestDuration.WithLabelValues(i.name, operation).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		metrics.GitProviderRequestErrors.WithLabelValues(i.name, operation).Inc()
+	}
+}
+
+func (i *InstrumentedGitProvider) GetOpenPRBranches(workspace string, repo string) (branches []string, err error) {
+	start := time.Now()
+	branches, err = i.provider.GetOpenPRBranches(workspace, repo)
+	i.observe("getopenprbranches", start, err)
+	return
+}
+
+func (i *InstrumentedGitProvider) Comment(workspace string, repo string, branch string, comment string, username *string, password *string) (err error) {
+	start := time.Now()
+	err = i.provider.Comment(workspace, repo, branch, comment, username, password)
+	i.observe("comment", start, err)
+	return
+}
+
+func (i *InstrumentedGitProvider) BasicAuth(clientId string, secret string) (auth *AuthModel, err error) {
+	start := time.Now()
+	auth, err = i.provider.BasicAuth(clientId, secret)
+	i.observe("basicauth", start, err)
+	return
+}
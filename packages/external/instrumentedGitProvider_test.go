@@ -0,0 +1,73 @@
+package external_test
+
+import (
+	"testing"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"bitbucket.org/centeva/collie/packages/metrics"
+	"bitbucket.org/centeva/collie/testutils"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// requestCount is the number of durations recorded for the provider and operation.
+func requestCount(t *testing.T, provider string, operation string) uint64 {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "collie_git_provider_request_duration_seconds" {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+
+			if labels["provider"] == provider && labels["operation"] == operation {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	return 0
+}
+
+func Test_InstrumentedGitProvider(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantErrors float64
+	}{
+		{name: "succeeded"},
+		{name: "failed", err: errors.New("unauthorized"), wantErrors: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := "test-" + tt.name
+			mockGitProvider := testutils.NewMockGitProvider()
+			mockGitProvider.GetBranchesRes = []string{"feature/a"}
+			mockGitProvider.GetBranchesErr = tt.err
+			sut := external.NewInstrumentedGitProvider(provider, mockGitProvider)
+
+			branches, err := sut.GetOpenPRBranches("workspace", "repo")
+
+			if err != tt.err || len(branches) != 1 || mockGitProvider.Called["getopenprbranches"] != 1 {
+				t.Errorf("GetOpenPRBranches() should return the result of the provider, got %v %v", branches, err)
+			}
+
+			if count := requestCount(t, provider, "getopenprbranches"); count != 1 {
+				t.Errorf("GetOpenPRBranches() should record its duration once, got %d", count)
+			}
+
+			if errorCount := testutil.ToFloat64(metrics.GitProviderRequestErrors.WithLabelValues(provider, "getopenprbranches")); errorCount != tt.wantErrors {
+				t.Errorf("GetOpenPRBranches() should count %v errors, got %v", tt.wantErrors, errorCount)
+			}
+		})
+	}
+}
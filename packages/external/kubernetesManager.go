@@ -3,84 +3,209 @@ package external
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 )
 
 type KubernetesManager struct {
-	clientset *kubernetes.Clientset
-	context   context.Context
+	clientset     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	context       context.Context
+	namespace     string
+}
+
+// NewKubernetesManagerForClient uses existing clients instead of connecting with Connect, such as the fake
+// clientset in tests. namespace is used when a job or lease namespace is not set.
+func NewKubernetesManagerForClient(context context.Context, clientset kubernetes.Interface, dynamicClient dynamic.Interface, namespace string) *KubernetesManager {
+	return &KubernetesManager{
+		clientset:     clientset,
+		dynamicClient: dynamicClient,
+		context:       context,
+		namespace:     namespace,
+	}
 }
 
 type IKubernetesManager interface {
-	InClusterConfig(context context.Context) (client *kubernetes.Clientset, err error)
-	OutClusterConfig(context context.Context, kubeconfig string) (client *kubernetes.Clientset, err error)
-	DeleteNamespace(namespace string) (err error)
-	GetNamespaces(label string) (namespaces []string, err error)
-	CreateCleanupJob(config *CleanupJobConfig) (err error)
+	Connect(context context.Context, cluster ClusterConfig) (err error)
+	Namespace() string
+	DeleteNamespace(ctx context.Context, namespace string) (err error)
+	WaitForNamespaceDeleted(ctx context.Context, namespace string, timeout time.Duration) (err error)
+	GetNamespaceStatus(ctx context.Context, namespace string) (status *NamespaceStatus, err error)
+	RemoveFinalizers(ctx context.Context, resources []NamespaceResource) (err error)
+	ScaleDownWorkloads(ctx context.Context, namespace string) (err error)
+	GetNamespaces(ctx context.Context, label string) (namespaces []string, err error)
+	ApplySecret(namespace string, name string, data map[string]string) (err error)
+	CreateCleanupJob(ctx context.Context, config *CleanupJobConfig) (action CleanupJobAction, err error)
+	RunWithLeaderElection(ctx context.Context, config *LeaderElectionConfig, onStartedLeading func(ctx context.Context)) (err error)
+}
+
+// ClusterConfig selects the cluster to connect to. Kubeconfig and Context follow the kubectl loading rules, an
+// empty Kubeconfig uses $KUBECONFIG then ~/.kube/config.
+type ClusterConfig struct {
+	Kubeconfig string `yaml:"kubeconfig"`
+	Context    string `yaml:"context"`
+	// Namespace overrides the namespace of the kubeconfig context, used when a job or lease namespace is not set.
+	Namespace string `yaml:"kubeNamespace"`
 }
 
-func (k *KubernetesManager) InClusterConfig(context context.Context) (client *kubernetes.Clientset, err error) {
-	config, err := rest.InClusterConfig()
+// Connect uses the in cluster config when running in a pod unless a kubeconfig or context is set, and falls
+// back to the kubeconfig loading rules when not running in a pod.
+func (k *KubernetesManager) Connect(context context.Context, cluster ClusterConfig) (err error) {
+	var config *rest.Config
+	namespace := cluster.Namespace
 
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to get config from k8s api")
+	if cluster.Kubeconfig == "" && cluster.Context == "" {
+		config, err = rest.InClusterConfig()
+
+		if err != nil && err != rest.ErrNotInCluster {
+			return errors.Wrap(err, "Failed to get in cluster config")
+		}
+
+		if config != nil && namespace == "" {
+			namespace = inClusterNamespace()
+		}
 	}
 
-	client, err = kubernetes.NewForConfig(config)
+	if config == nil {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = cluster.Kubeconfig
 
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to create client")
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: cluster.Context}
+		overrides.Context.Namespace = cluster.Namespace
+
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+		if config, err = clientConfig.ClientConfig(); err != nil {
+			return errors.Wrap(err, "Failed to load kubeconfig, not running in a cluster")
+		}
+
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return errors.Wrap(err, "Failed to get namespace from kubeconfig")
+		}
+	}
+
+	if k.clientset, err = kubernetes.NewForConfig(config); err != nil {
+		return errors.Wrapf(err, "Failed to create client")
+	}
+
+	if k.dynamicClient, err = dynamic.NewForConfig(config); err != nil {
+		return errors.Wrapf(err, "Failed to create dynamic client")
 	}
 
-	k.clientset = client
 	k.context = context
+	k.namespace = namespace
 	return
 }
 
-func (k *KubernetesManager) OutClusterConfig(context context.Context, kubeconfig string) (client *kubernetes.Clientset, err error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+// Namespace is the namespace of the kubeconfig context or the pod's service account.
+func (k *KubernetesManager) Namespace() string {
+	return k.namespace
+}
 
+func inClusterNamespace() string {
+	namespace, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to build config from file: %s", kubeconfig)
+		return metav1.NamespaceDefault
 	}
 
-	client, err = kubernetes.NewForConfig(config)
+	return strings.TrimSpace(string(namespace))
+}
+
+func (k *KubernetesManager) DeleteNamespace(ctx context.Context, namespace string) (err error) {
+
+	deletePolicy := metav1.DeletePropagationForeground
+	opts := &metav1.DeleteOptions{
+		PropagationPolicy: &deletePolicy,
+	}
+
+	if err := k.clientset.CoreV1().Namespaces().Delete(ctx, namespace, *opts); err != nil {
+		return errors.Wrap(err, "Failed to delete namespace")
+	}
+	return
+}
+
+// ApplySecret creates the Secret, or replaces the data of an existing Secret with the same name.
+func (k *KubernetesManager) ApplySecret(namespace string, name string, data map[string]string) (err error) {
+	if namespace == "" {
+		namespace = k.namespace
+	}
+
+	secrets := k.clientset.CoreV1().Secrets(namespace)
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "collie"},
+		},
+		Type:       v1.SecretTypeOpaque,
+		StringData: data,
+	}
+
+	_, err = secrets.Create(k.context, secret, metav1.CreateOptions{})
+
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(k.context, secret, metav1.UpdateOptions{})
+	}
 
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to create client")
+		return errors.Wrapf(err, "Failed to apply secret %s/%s", namespace, name)
 	}
 
-	k.clientset = client
-	k.context = context
 	return
 }
 
-func (k *KubernetesManager) DeleteNamespace(namespace string) (err error) {
+// ScaleDownWorkloads scales every Deployment and StatefulSet in the namespace to 0 replicas.
+func (k *KubernetesManager) ScaleDownWorkloads(ctx context.Context, namespace string) (err error) {
+	scaleToZero := []byte(`{"spec":{"replicas":0}}`)
 
-	deletePolicy := metav1.DeletePropagationForeground
-	opts := &metav1.DeleteOptions{
-		PropagationPolicy: &deletePolicy,
+	deployments, err := k.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to list deployments")
 	}
 
-	if err := k.clientset.CoreV1().Namespaces().Delete(k.context, namespace, *opts); err != nil {
-		return errors.Wrap(err, "Failed to delete namespace")
+	for _, deployment := range deployments.Items {
+		if _, err = k.clientset.AppsV1().Deployments(namespace).Patch(ctx, deployment.Name, types.MergePatchType, scaleToZero, metav1.PatchOptions{}); err != nil {
+			return errors.Wrapf(err, "Failed to scale down deployment %s", deployment.Name)
+		}
 	}
+
+	statefulSets, err := k.clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "Failed to list statefulsets")
+	}
+
+	for _, statefulSet := range statefulSets.Items {
+		if _, err = k.clientset.AppsV1().StatefulSets(namespace).Patch(ctx, statefulSet.Name, types.MergePatchType, scaleToZero, metav1.PatchOptions{}); err != nil {
+			return errors.Wrapf(err, "Failed to scale down statefulset %s", statefulSet.Name)
+		}
+	}
+
 	return
 }
 
-func (k *KubernetesManager) GetNamespaces(label string) (namespaces []string, err error) {
+func (k *KubernetesManager) GetNamespaces(ctx context.Context, label string) (namespaces []string, err error) {
 
-	res, err := k.clientset.CoreV1().Namespaces().List(k.context, metav1.ListOptions{LabelSelector: label})
+	res, err := k.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: label})
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to get namespaces")
 	}
@@ -95,54 +220,70 @@ func (k *KubernetesManager) GetNamespaces(label string) (namespaces []string, er
 }
 
 type CleanupJobConfig struct {
-	Image            string `yaml:"image"`
-	ImagePullSecret  string `yaml:"imagePullSecret"`
-	JobNamespace     string `yaml:"namespace"`
-	ConnectionString string `yaml:"connectionString"`
-	ServiceAccount   string `yaml:"serviceAccountName"`
-	Timeout          string `yaml:"timeout"`
-	Name             string
+	Image                  string                 `yaml:"image"`
+	ImagePullSecret        string                 `yaml:"imagePullSecret"`
+	JobNamespace           string                 `yaml:"namespace"`
+	ConnectionString       string                 `yaml:"connectionString"`
+	ConnectionStringSecret *SecretKeyRef          `yaml:"connectionStringSecret,omitempty"`
+	ServiceAccount         string                 `yaml:"serviceAccountName"`
+	Timeout                string                 `yaml:"timeout"`
+	ExistingJobPolicy      ExistingJobPolicy      `yaml:"existingJobPolicy"`
+	StepTimeouts           *StepTimeouts          `yaml:"stepTimeouts,omitempty"`
+	Actions                []TeardownActionConfig `yaml:"actions,omitempty"`
+	Backup                 *BackupConfig          `yaml:"backup,omitempty"`
+	DropRole               bool                   `yaml:"dropRole,omitempty"`
+	TemplateFile           string                 `yaml:"template"`
+	Overrides              *CleanupJobOverrides   `yaml:"overrides,omitempty"`
+	Template               *batchv1.Job           `yaml:"-"`
+	Name                   string
 }
 
-func buildCleanupJob(name string, config *CleanupJobConfig) *batchv1.Job {
-	ttlSecondsAfterFinished := int32(1)
+// StepTimeouts limit how long each teardown step may take, empty values use the Teardown defaults.
+type StepTimeouts struct {
+	ScaleDown string `yaml:"scaleDown"`
+	Namespace string `yaml:"namespace"`
+	Database  string `yaml:"database"`
+	Backup    string `yaml:"backup"`
+}
 
-	return &batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: config.JobNamespace,
-		},
-		Spec: batchv1.JobSpec{
-			TTLSecondsAfterFinished: &ttlSecondsAfterFinished,
-			Template: v1.PodTemplateSpec{
-				Spec: v1.PodSpec{
-					RestartPolicy: v1.RestartPolicyNever,
-					ImagePullSecrets: []v1.LocalObjectReference{
-						{Name: config.ImagePullSecret},
-					},
-					Containers: []v1.Container{
-						{
-							Name:            "deletedb",
-							Image:           config.Image,
-							ImagePullPolicy: v1.PullAlways,
-							Args:            []string{"DeleteDatabase", config.Name, fmt.Sprintf(`--ConnectionString=%s`, config.ConnectionString)},
-						},
-						{
-							Name:            "deletenamespace",
-							Image:           config.Image,
-							ImagePullPolicy: v1.PullAlways,
-							Args:            []string{"DeleteNamespace", config.Name},
-						},
-					},
-				},
-			},
-		},
-	}
+// SecretKeyRef points at a key in an existing Secret in the job namespace.
+type SecretKeyRef struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
 }
 
-func (k *KubernetesManager) CreateCleanupJob(config *CleanupJobConfig) (err error) {
+// ExistingJobPolicy decides what CreateCleanupJob does when a cleanup job with the same name already exists.
+type ExistingJobPolicy string
+
+const (
+	ExistingJobReuse   ExistingJobPolicy = "reuse"
+	ExistingJobReplace ExistingJobPolicy = "replace"
+	ExistingJobSkip    ExistingJobPolicy = "skip"
+)
+
+// CleanupJobAction reports which path CreateCleanupJob took.
+type CleanupJobAction string
+
+const (
+	CleanupJobCreated  CleanupJobAction = "created"
+	CleanupJobReused   CleanupJobAction = "reused"
+	CleanupJobReplaced CleanupJobAction = "replaced"
+	CleanupJobSkipped  CleanupJobAction = "skipped"
+)
+
+// CreateCleanupJob creates the cleanup job and waits for it to finish. Every call to the cluster uses ctx, so a caller
+// that lost its lease stops creating and watching jobs once ctx is cancelled.
+func (k *KubernetesManager) CreateCleanupJob(ctx context.Context, config *CleanupJobConfig) (action CleanupJobAction, err error) {
 	if config.Timeout == "" {
-		config.Timeout = "2m"
+		config.Timeout = "10m"
+	}
+
+	if config.ExistingJobPolicy == "" {
+		config.ExistingJobPolicy = ExistingJobReuse
+	}
+
+	if config.JobNamespace == "" {
+		config.JobNamespace = k.namespace
 	}
 
 	name := fmt.Sprintf("cleanup-%s", config.Name)
@@ -150,52 +291,285 @@ func (k *KubernetesManager) CreateCleanupJob(config *CleanupJobConfig) (err erro
 
 	dur, err := time.ParseDuration(config.Timeout)
 	if err != nil {
-		return errors.Wrap(err, "Failed to parse Timeout")
+		return "", errors.Wrap(err, "Failed to parse Timeout")
+	}
+
+	jobs := k.clientset.BatchV1().Jobs(config.JobNamespace)
+	existing, err := jobs.Get(ctx, name, metav1.GetOptions{})
+
+	switch {
+	case apierrors.IsNotFound(err):
+		action = CleanupJobCreated
+	case err != nil:
+		return "", errors.Wrapf(err, "Failed to check for existing job %s", name)
+	default:
+		switch config.ExistingJobPolicy {
+		case ExistingJobSkip:
+			log.Printf("Job '%s' already exists, skipping", name)
+			return CleanupJobSkipped, nil
+		case ExistingJobReplace:
+			log.Printf("Job '%s' already exists, replacing", name)
+			if err = k.deleteJob(ctx, config.JobNamespace, name); err != nil {
+				return "", err
+			}
+			if err = k.waitForJobDeleted(ctx, config.JobNamespace, name, dur); err != nil {
+				return "", err
+			}
+			action = CleanupJobReplaced
+		case ExistingJobReuse:
+			// A failed job is kept for its logs until its TTL, watching it would only report the same failure again.
+			if _, failed := checkJobCompleted(existing); failed != nil {
+				log.Printf("Job '%s' already exists and failed (%s), replacing", name, failed)
+				if err = k.deleteJob(ctx, config.JobNamespace, name); err != nil {
+					return "", err
+				}
+				if err = k.waitForJobDeleted(ctx, config.JobNamespace, name, dur); err != nil {
+					return "", err
+				}
+				action = CleanupJobReplaced
+				break
+			}
+
+			log.Printf("Job '%s' already exists, watching existing job", name)
+			action = CleanupJobReused
+		default:
+			return "", errors.Errorf("Unknown existingJobPolicy '%s' must be one of [reuse|replace|skip]", config.ExistingJobPolicy)
+		}
 	}
 
-	_, err = k.clientset.BatchV1().Jobs(config.JobNamespace).Create(k.context, cleanupJob, metav1.CreateOptions{})
+	if action != CleanupJobReused {
+		if err = k.createJob(ctx, name, config, cleanupJob); err != nil {
+			return "", err
+		}
+	}
+
+	if err = k.watchJob(ctx, config.JobNamespace, name, dur); err != nil {
+		return "", err
+	}
+
+	return action, nil
+}
+
+// createJob creates the job and then, when the connection string is inline or there are teardown actions, a Secret
+// holding them that is owned by the job, so it is garbage collected along with the job and never left without an
+// owner. The job's pod waits for the Secret before it starts. The job is deleted when the Secret can't be created.
+func (k *KubernetesManager) createJob(ctx context.Context, name string, config *CleanupJobConfig, cleanupJob *batchv1.Job) (err error) {
+	data, err := cleanupSecretData(config)
+
+	if err != nil {
+		return err
+	}
+
+	job, err := k.clientset.BatchV1().Jobs(config.JobNamespace).Create(ctx, cleanupJob, metav1.CreateOptions{})
+
 	if err != nil {
 		return errors.Wrap(err, "Failed to create cleanup job")
 	}
 
-	durSeconds := int64(dur.Seconds())
+	if len(data) == 0 {
+		return
+	}
+
+	blockOwnerDeletion := true
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: config.JobNamespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "collie"},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion:         "batch/v1",
+					Kind:               "Job",
+					Name:               job.Name,
+					UID:                job.UID,
+					BlockOwnerDeletion: &blockOwnerDeletion,
+				},
+			},
+		},
+		Type:       v1.SecretTypeOpaque,
+		StringData: data,
+	}
+
+	secrets := k.clientset.CoreV1().Secrets(config.JobNamespace)
+	_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+
+	if apierrors.IsAlreadyExists(err) {
+		// Left over from a job that was deleted without its Secret, the owner reference is replaced too.
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+
+	if err != nil {
+		if deleteErr := k.deleteJob(ctx, config.JobNamespace, name); deleteErr != nil {
+			log.Printf("Failed to delete job %s after its secret could not be created: %s", name, deleteErr)
+		}
+		return errors.Wrap(err, "Failed to create cleanup secret")
+	}
+
+	return
+}
+
+func (k *KubernetesManager) deleteJob(ctx context.Context, namespace string, name string) (err error) {
+	deletePolicy := metav1.DeletePropagationForeground
+	err = k.clientset.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "Failed to delete job")
+	}
 
-	watcher, err := k.clientset.BatchV1().Jobs(config.JobNamespace).Watch(k.context, metav1.ListOptions{
-		LabelSelector:  fmt.Sprintf("job-name=%s", name),
-		TimeoutSeconds: &durSeconds,
+	return nil
+}
+
+func (k *KubernetesManager) waitForJobDeleted(ctx context.Context, namespace string, name string, timeout time.Duration) (err error) {
+	err = wait.PollImmediateWithContext(ctx, time.Second, timeout, func(ctx context.Context) (bool, error) {
+		_, err := k.clientset.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+
+		return false, err
 	})
 
 	if err != nil {
-		return errors.Wrapf(err, "Failed to create watcher: %+v", watcher)
+		return errors.Wrapf(err, "Failed waiting for job %s to be deleted", name)
 	}
 
-	for event := range watcher.ResultChan() {
-		job := event.Object.(*batchv1.Job)
+	return
+}
+
+// watchJob waits until the job succeeds or fails. The job is re-read whenever the watch expires
+// so a completion that happened between two watches is not missed.
+func (k *KubernetesManager) watchJob(ctx context.Context, namespace string, name string, dur time.Duration) (err error) {
+	jobs := k.clientset.BatchV1().Jobs(namespace)
+	deadline := time.Now().Add(dur)
+
+	for {
+		remaining := int64(time.Until(deadline).Seconds())
+
+		if remaining <= 0 {
+			return errors.Errorf("Timeout error watching job %s", name)
+		}
+
+		job, err := jobs.Get(ctx, name, metav1.GetOptions{})
+
+		if apierrors.IsNotFound(err) {
+			return errors.Errorf("Job %s was deleted before it finished", name)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get job %s", name)
+		}
+
+		if done, err := k.checkJob(ctx, job); done || err != nil {
+			return err
+		}
+
+		watcher, err := jobs.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", name).String(),
+			ResourceVersion: job.ResourceVersion,
+			TimeoutSeconds:  &remaining,
+		})
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create watcher for job %s", name)
+		}
+
+		done, err := k.watchJobEvents(ctx, watcher, name, deadline)
+		watcher.Stop()
+
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// watchJobEvents handles events until the job finishes, the watch ends or the deadline passes, done is false when
+// the watch needs to be restarted.
+func (k *KubernetesManager) watchJobEvents(ctx context.Context, watcher watch.Interface, name string, deadline time.Time) (done bool, err error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		var event watch.Event
+		var ok bool
+
+		select {
+		case event, ok = <-watcher.ResultChan():
+			if !ok {
+				return false, nil
+			}
+		case <-timer.C:
+			return false, nil
+		case <-ctx.Done():
+			return true, errors.Wrapf(ctx.Err(), "Stopped watching job %s", name)
+		}
 
 		switch event.Type {
-		case watch.Modified:
-			ok, err := checkJobCompleted(job)
+		case watch.Added, watch.Modified:
+			job, ok := event.Object.(*batchv1.Job)
 
-			if err != nil {
-				return errors.Wrapf(err, "Error watching job %s", job.Name)
+			if !ok {
+				continue
 			}
 
-			if ok {
-				log.Printf("Job '%s' finished sucessfully", job.Name)
+			if done, err := k.checkJob(ctx, job); done || err != nil {
+				return true, err
+			}
+		case watch.Deleted:
+			return true, errors.Errorf("Job %s was deleted before it finished", name)
+		case watch.Error:
+			log.Printf("Watch error for job %s, restarting watch: %v", name, apierrors.FromObject(event.Object))
+			return false, nil
+		}
+	}
+}
+
+// checkJob deletes the job once it succeeded, and adds the container logs to the error when it failed.
+func (k *KubernetesManager) checkJob(ctx context.Context, job *batchv1.Job) (done bool, err error) {
+	ok, err := checkJobCompleted(job)
 
-				deletePolicy := metav1.DeletePropagationForeground
-				err = k.clientset.BatchV1().Jobs(config.JobNamespace).Delete(k.context, name, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+	if err != nil {
+		return true, errors.Wrapf(err, "Job %s failed%s", job.Name, k.jobLogs(ctx, job.Namespace, job.Name))
+	}
 
-				if err != nil {
-					return errors.Wrap(err, "Failed to delete job")
-				}
+	if !ok {
+		return false, nil
+	}
+
+	log.Printf("Job '%s' finished sucessfully", job.Name)
+	return true, k.deleteJob(ctx, job.Namespace, job.Name)
+}
+
+// jobLogs returns the tail of the logs of every container in the job's pods.
+func (k *KubernetesManager) jobLogs(ctx context.Context, namespace string, name string) string {
+	pods, err := k.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", name),
+	})
+
+	if err != nil {
+		return fmt.Sprintf(", failed to get pods: %s", err)
+	}
+
+	var logs strings.Builder
+	tailLines := int64(50)
 
-				return nil
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			raw, err := k.clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, &v1.PodLogOptions{
+				Container: container.Name,
+				TailLines: &tailLines,
+			}).DoRaw(ctx)
+
+			if err != nil {
+				fmt.Fprintf(&logs, "\n--- %s/%s: failed to get logs: %s", pod.Name, container.Name, err)
+				continue
 			}
+
+			fmt.Fprintf(&logs, "\n--- %s/%s ---\n%s", pod.Name, container.Name, strings.TrimSpace(string(raw)))
 		}
 	}
 
-	return errors.Errorf("Timeout error watching job %s", name)
+	return logs.String()
 }
 
 func checkJobCompleted(job *batchv1.Job) (res bool, err error) {
@@ -204,15 +578,158 @@ func checkJobCompleted(job *batchv1.Job) (res bool, err error) {
 		return false, errors.New("Job is nil")
 	}
 
-	if len(job.Status.Conditions) > 0 {
-		latest := job.Status.Conditions[len(job.Status.Conditions)-1]
-		switch latest.Type {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != v1.ConditionTrue {
+			continue
+		}
+
+		switch condition.Type {
 		case batchv1.JobComplete:
 			return true, nil
-		default:
-			return false, errors.Errorf("Job %T: %s Details: %s", latest.Type, latest.Reason, latest.Message)
+		case batchv1.JobFailed:
+			return false, errors.Errorf("%s: %s", condition.Reason, condition.Message)
 		}
 	}
 
-	return false, nil
+	if job.Spec.BackoffLimit != nil && job.Status.Failed > *job.Spec.BackoffLimit {
+		return false, errors.Errorf("BackoffLimitExceeded: %d failed pods", job.Status.Failed)
+	}
+
+	completions := int32(1)
+	if job.Spec.Completions != nil {
+		completions = *job.Spec.Completions
+	}
+
+	return job.Status.Succeeded >= completions, nil
+}
+
+type LeaderElectionConfig struct {
+	Name          string `yaml:"name"`
+	Namespace     string `yaml:"namespace"`
+	Identity      string `yaml:"identity"`
+	LeaseDuration string `yaml:"leaseDuration"`
+	RenewDeadline string `yaml:"renewDeadline"`
+	RetryPeriod   string `yaml:"retryPeriod"`
+}
+
+func parseDurationOrDefault(value string, def time.Duration) (dur time.Duration, err error) {
+	if value == "" {
+		return def, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// RunWithLeaderElection blocks until the Lease is held and then calls onStartedLeading. The ctx passed to
+// onStartedLeading is cancelled if the lease is lost. The lease is released once onStartedLeading returns
+// so a standby instance can take over, returns without calling onStartedLeading if ctx is cancelled first.
+func (k *KubernetesManager) RunWithLeaderElection(ctx context.Context, config *LeaderElectionConfig, onStartedLeading func(ctx context.Context)) (err error) {
+	if config.Namespace == "" {
+		lease := *config
+		lease.Namespace = k.namespace
+		config = &lease
+	}
+
+	if config.Name == "" || config.Namespace == "" {
+		return errors.New("Leader election requires a lease name and namespace")
+	}
+
+	identity := config.Identity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return errors.Wrap(err, "Failed to get hostname for leader election identity")
+		}
+		identity = fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())
+	}
+
+	leaseDuration, err := parseDurationOrDefault(config.LeaseDuration, 15*time.Second)
+	if err != nil {
+		return errors.Wrap(err, "Failed to parse leaseDuration")
+	}
+
+	renewDeadline, err := parseDurationOrDefault(config.RenewDeadline, 10*time.Second)
+	if err != nil {
+		return errors.Wrap(err, "Failed to parse renewDeadline")
+	}
+
+	retryPeriod, err := parseDurationOrDefault(config.RetryPeriod, 2*time.Second)
+	if err != nil {
+		return errors.Wrap(err, "Failed to parse retryPeriod")
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      config.Name,
+			Namespace: config.Namespace,
+		},
+		Client: k.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	leading := make(chan struct{})
+	stopped := make(chan struct{})
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				close(leading)
+			},
+			OnStoppedLeading: func() {
+				log.Printf("Lease %s/%s released by %s", config.Namespace, config.Name, identity)
+			},
+			OnNewLeader: func(holder string) {
+				if holder == identity {
+					log.Printf("Lease %s/%s acquired by %s", config.Namespace, config.Name, identity)
+					return
+				}
+				log.Printf("Lease %s/%s held by %s, waiting", config.Namespace, config.Name, holder)
+			},
+		},
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "Failed to create leader elector")
+	}
+
+	electCtx, electCancel := context.WithCancel(ctx)
+	defer electCancel()
+
+	go func() {
+		elector.Run(electCtx)
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return
+	case <-leading:
+	}
+
+	leaderCtx, leaderCancel := context.WithCancel(ctx)
+	defer leaderCancel()
+
+	go func() {
+		select {
+		case <-stopped:
+			log.Printf("Lost lease %s/%s", config.Namespace, config.Name)
+			leaderCancel()
+		case <-leaderCtx.Done():
+		}
+	}()
+
+	onStartedLeading(leaderCtx)
+
+	leaderCancel()
+	electCancel()
+	<-stopped
+	return
 }
@@ -0,0 +1,600 @@
+package external_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testJobNamespace = "jobs"
+
+func kubernetesTestSetup(objects ...runtime.Object) (*external.KubernetesManager, *fake.Clientset) {
+	clientset := fake.NewSimpleClientset(objects...)
+	return external.NewKubernetesManagerForClient(context.Background(), clientset, nil, testJobNamespace), clientset
+}
+
+func testJobConfig() *external.CleanupJobConfig {
+	return &external.CleanupJobConfig{
+		Image:            "testImage",
+		ConnectionString: "testConnectionString",
+		Timeout:          "5s",
+		Name:             "test-1",
+	}
+}
+
+func jobWithCondition(conditionType batchv1.JobConditionType) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "cleanup-test-1", Namespace: testJobNamespace},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{Type: conditionType, Status: v1.ConditionTrue},
+			},
+		},
+	}
+}
+
+// watchJobEvents makes each watch on jobs send the next list of events, later watches send nothing.
+func watchJobEvents(clientset *fake.Clientset, events ...[]watch.Event) {
+	watches := 0
+
+	clientset.PrependWatchReactor("jobs", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFake()
+
+		if watches < len(events) {
+			go func(events []watch.Event) {
+				for _, event := range events {
+					watcher.Action(event.Type, event.Object)
+				}
+			}(events[watches])
+		}
+
+		watches++
+		return true, watcher, nil
+	})
+}
+
+func createdJob(clientset *fake.Clientset) (created *batchv1.Job) {
+	for _, action := range clientset.Actions() {
+		if create, ok := action.(k8stesting.CreateAction); ok && action.GetResource().Resource == "jobs" {
+			created = create.GetObject().(*batchv1.Job)
+		}
+	}
+
+	return
+}
+
+func Test_CreateCleanupJob(t *testing.T) {
+	sut, clientset := kubernetesTestSetup()
+	watchJobEvents(clientset, []watch.Event{
+		{Type: watch.Modified, Object: &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "cleanup-test-1", Namespace: testJobNamespace}}},
+		{Type: watch.Modified, Object: jobWithCondition(batchv1.JobComplete)},
+	})
+
+	action, err := sut.CreateCleanupJob(context.Background(), testJobConfig())
+
+	if err != nil {
+		t.Fatalf("CreateCleanupJob() should not error, got %s", err)
+	}
+
+	if action != external.CleanupJobCreated {
+		t.Errorf("CreateCleanupJob() should return %s, got %s", external.CleanupJobCreated, action)
+	}
+
+	created := createdJob(clientset)
+
+	if created == nil {
+		t.Fatalf("CreateCleanupJob() should have created a job")
+	}
+
+	containers := created.Spec.Template.Spec.Containers
+	if len(containers) != 1 || containers[0].Name != external.TeardownContainerName || strings.Join(containers[0].Args, " ") != "Teardown test-1" {
+		t.Errorf("CreateCleanupJob() should create a single teardown container, got %+v", containers)
+	}
+
+	if containers[0].Env[0].ValueFrom.SecretKeyRef.Name != "cleanup-test-1" {
+		t.Errorf("CreateCleanupJob() should read the connection string from the cleanup secret, got %+v", containers[0].Env)
+	}
+
+	if _, err := clientset.BatchV1().Jobs(testJobNamespace).Get(context.Background(), "cleanup-test-1", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("CreateCleanupJob() should delete the job once it succeeded, got %v", err)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(testJobNamespace).Get(context.Background(), "cleanup-test-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("CreateCleanupJob() should create a secret for the inline connection string, got %s", err)
+	}
+
+	if secret.StringData[external.ConnectionStringKey] != "testConnectionString" {
+		t.Errorf("Secret should hold the connection string, got %+v", secret.StringData)
+	}
+
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Name != "cleanup-test-1" {
+		t.Errorf("Secret should be owned by the job, got %+v", secret.OwnerReferences)
+	}
+}
+
+func Test_CreateCleanupJobSecretOwnedWhenCreated(t *testing.T) {
+	sut, clientset := kubernetesTestSetup()
+	watchJobEvents(clientset, []watch.Event{{Type: watch.Modified, Object: jobWithCondition(batchv1.JobComplete)}})
+
+	if _, err := sut.CreateCleanupJob(context.Background(), testJobConfig()); err != nil {
+		t.Fatalf("CreateCleanupJob() should not error, got %s", err)
+	}
+
+	var writes []string
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() != "create" && action.GetVerb() != "update" {
+			continue
+		}
+
+		writes = append(writes, action.GetVerb()+" "+action.GetResource().Resource)
+
+		if create, ok := action.(k8stesting.CreateAction); ok && action.GetResource().Resource == "secrets" {
+			if owners := create.GetObject().(*v1.Secret).OwnerReferences; len(owners) != 1 || owners[0].Kind != "Job" {
+				t.Errorf("CreateCleanupJob() should create the secret owned by the job, got %+v", owners)
+			}
+		}
+	}
+
+	if want := "create jobs,create secrets"; strings.Join(writes, ",") != want {
+		t.Errorf("CreateCleanupJob() should create the job and then its secret, want %s got %s", want, strings.Join(writes, ","))
+	}
+}
+
+func Test_CreateCleanupJobSecretFails(t *testing.T) {
+	sut, clientset := kubernetesTestSetup()
+	clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	_, err := sut.CreateCleanupJob(context.Background(), testJobConfig())
+
+	if err == nil || !strings.Contains(err.Error(), "Failed to create cleanup secret") {
+		t.Fatalf("CreateCleanupJob() should error when the secret can't be created, got %v", err)
+	}
+
+	if _, err := clientset.BatchV1().Jobs(testJobNamespace).Get(context.Background(), "cleanup-test-1", metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("CreateCleanupJob() should delete the job when its secret can't be created, got %v", err)
+	}
+}
+
+func Test_CreateCleanupJobTemplateOverrides(t *testing.T) {
+	sut, clientset := kubernetesTestSetup()
+	watchJobEvents(clientset, []watch.Event{{Type: watch.Modified, Object: jobWithCondition(batchv1.JobComplete)}})
+
+	template, err := external.ParseJobTemplate([]byte(`
+apiVersion: v1
+kind: PodTemplate
+template:
+  metadata:
+    labels:
+      team: platform
+  spec:
+    nodeSelector:
+      pool: default
+    containers:
+      - name: teardown
+        env:
+          - name: FROM_TEMPLATE
+            value: "true"
+        resources:
+          requests:
+            cpu: 50m
+      - name: sidecar
+        image: proxy
+`))
+	if err != nil {
+		t.Fatalf("ParseJobTemplate() should not error, got %s", err)
+	}
+
+	backoffLimit := int32(0)
+	memory := resource.MustParse("128Mi")
+	config := testJobConfig()
+	config.Template = template
+	config.Overrides = &external.CleanupJobOverrides{
+		Labels:          map[string]string{"app": "collie"},
+		BackoffLimit:    &backoffLimit,
+		NodeSelector:    map[string]string{"pool": "tools"},
+		ImagePullPolicy: v1.PullIfNotPresent,
+		Resources:       &v1.ResourceRequirements{Limits: v1.ResourceList{v1.ResourceMemory: memory}},
+		Env:             []v1.EnvVar{{Name: "FROM_OVERRIDES", Value: "true"}},
+	}
+
+	if _, err := sut.CreateCleanupJob(context.Background(), config); err != nil {
+		t.Fatalf("CreateCleanupJob() should not error, got %s", err)
+	}
+
+	created := createdJob(clientset)
+	spec := created.Spec.Template.Spec
+
+	if created.Spec.BackoffLimit == nil || *created.Spec.BackoffLimit != 0 {
+		t.Errorf("Job should have the backoffLimit override, got %v", created.Spec.BackoffLimit)
+	}
+
+	if created.Labels["app"] != "collie" || created.Spec.Template.Labels["app"] != "collie" || created.Spec.Template.Labels["team"] != "platform" {
+		t.Errorf("Job and pod should merge the override labels into the template labels, got %v and %v", created.Labels, created.Spec.Template.Labels)
+	}
+
+	if spec.NodeSelector["pool"] != "tools" {
+		t.Errorf("Pod should have the nodeSelector override, got %v", spec.NodeSelector)
+	}
+
+	if spec.RestartPolicy != v1.RestartPolicyNever {
+		t.Errorf("Pod should default restartPolicy to Never, got %s", spec.RestartPolicy)
+	}
+
+	if len(spec.Containers) != 2 || spec.Containers[0].Name != external.TeardownContainerName || spec.Containers[1].Name != "sidecar" {
+		t.Fatalf("Pod should have the teardown container followed by the other template containers, got %+v", spec.Containers)
+	}
+
+	teardown := spec.Containers[0]
+
+	if teardown.Image != "testImage" || strings.Join(teardown.Args, " ") != "Teardown test-1" {
+		t.Errorf("Teardown container should get the image and args, got %s %v", teardown.Image, teardown.Args)
+	}
+
+	if teardown.ImagePullPolicy != v1.PullIfNotPresent {
+		t.Errorf("Teardown container should have the imagePullPolicy override, got %s", teardown.ImagePullPolicy)
+	}
+
+	if cpu := teardown.Resources.Requests.Cpu().String(); cpu != "0" {
+		t.Errorf("Teardown container resources should be replaced by the override, got cpu request %s", cpu)
+	}
+
+	if limit := teardown.Resources.Limits.Memory().String(); limit != "128Mi" {
+		t.Errorf("Teardown container should have the memory limit override, got %s", limit)
+	}
+
+	var env []string
+	for _, e := range teardown.Env {
+		env = append(env, e.Name)
+	}
+
+	if want := "FROM_TEMPLATE " + external.ConnectionStringEnv + " FROM_OVERRIDES"; strings.Join(env, " ") != want {
+		t.Errorf("Teardown container env should be the template, cleanup and override env, want %s got %s", want, strings.Join(env, " "))
+	}
+
+	if spec.Containers[1].Image != "proxy" || len(spec.Containers[1].Env) != 0 {
+		t.Errorf("Other template containers should be kept as is, got %+v", spec.Containers[1])
+	}
+}
+
+func Test_CreateCleanupJobBackupArgs(t *testing.T) {
+	sut, clientset := kubernetesTestSetup()
+	watchJobEvents(clientset, []watch.Event{{Type: watch.Modified, Object: jobWithCondition(batchv1.JobComplete)}})
+
+	config := testJobConfig()
+	config.StepTimeouts = &external.StepTimeouts{Backup: "30m"}
+	config.Backup = &external.BackupConfig{Destination: "s3://backups/previews", Endpoint: "minio:9000", Insecure: true, Retention: 3}
+	config.DropRole = true
+
+	if _, err := sut.CreateCleanupJob(context.Background(), config); err != nil {
+		t.Fatalf("CreateCleanupJob() should not error, got %s", err)
+	}
+
+	created := createdJob(clientset)
+	args := strings.Join(created.Spec.Template.Spec.Containers[0].Args, " ")
+	want := "Teardown test-1 --BackupTimeout=30m --DropRole --Backup=s3://backups/previews --BackupEndpoint=minio:9000 --BackupInsecure --BackupRetention=3"
+
+	if args != want {
+		t.Errorf("CreateCleanupJob() should pass the backup and drop role flags to Teardown, want %s got %s", want, args)
+	}
+}
+
+func Test_CreateCleanupJobWatchEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  [][]watch.Event
+		wantErr string
+	}{
+		{
+			name:    "failed",
+			events:  [][]watch.Event{{{Type: watch.Modified, Object: jobWithCondition(batchv1.JobFailed)}}},
+			wantErr: "Job cleanup-test-1 failed",
+		},
+		{
+			name:    "deleted",
+			events:  [][]watch.Event{{{Type: watch.Deleted, Object: jobWithCondition(batchv1.JobComplete)}}},
+			wantErr: "deleted before it finished",
+		},
+		{
+			name: "watch error restarts the watch",
+			events: [][]watch.Event{
+				{{Type: watch.Error, Object: &metav1.Status{Message: "too old resource version"}}},
+				{{Type: watch.Modified, Object: jobWithCondition(batchv1.JobComplete)}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, clientset := kubernetesTestSetup()
+			watchJobEvents(clientset, tt.events...)
+
+			_, err := sut.CreateCleanupJob(context.Background(), testJobConfig())
+
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("CreateCleanupJob() should not error, got %s", err)
+			}
+
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("CreateCleanupJob() should error with %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func jobWithStatus(backoffLimit *int32, completions *int32, status batchv1.JobStatus) *batchv1.Job {
+	job := jobWithCondition(batchv1.JobComplete)
+	job.Spec.BackoffLimit = backoffLimit
+	job.Spec.Completions = completions
+	job.Status = status
+	return job
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func Test_CreateCleanupJobCompletion(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     *batchv1.Job
+		wantErr string
+	}{
+		{
+			name: "succeeded without conditions",
+			job:  jobWithStatus(nil, nil, batchv1.JobStatus{Succeeded: 1}),
+		},
+		{
+			name:    "backoff limit exceeded without conditions",
+			job:     jobWithStatus(int32Ptr(2), nil, batchv1.JobStatus{Failed: 3}),
+			wantErr: "BackoffLimitExceeded: 3 failed pods",
+		},
+		{
+			name:    "failed condition reason and message",
+			job:     jobWithStatus(nil, nil, batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: v1.ConditionTrue, Reason: "DeadlineExceeded", Message: "Job was active longer than specified deadline"}}}),
+			wantErr: "DeadlineExceeded: Job was active longer than specified deadline",
+		},
+		{
+			name: "complete after retries within the backoff limit",
+			job:  jobWithStatus(int32Ptr(2), nil, batchv1.JobStatus{Failed: 2, Succeeded: 1}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, clientset := kubernetesTestSetup()
+			watchJobEvents(clientset, []watch.Event{{Type: watch.Modified, Object: tt.job}})
+
+			_, err := sut.CreateCleanupJob(context.Background(), testJobConfig())
+
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("CreateCleanupJob() should not error, got %s", err)
+			}
+
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("CreateCleanupJob() should error with %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_CreateCleanupJobIncomplete(t *testing.T) {
+	tests := []struct {
+		name string
+		job  *batchv1.Job
+	}{
+		{name: "fewer succeeded than completions", job: jobWithStatus(nil, int32Ptr(2), batchv1.JobStatus{Succeeded: 1})},
+		{name: "failed pods within the backoff limit", job: jobWithStatus(int32Ptr(6), nil, batchv1.JobStatus{Failed: 1})},
+		{name: "condition not true", job: jobWithStatus(nil, nil, batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: v1.ConditionFalse}}})},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			sut, clientset := kubernetesTestSetup()
+			watchJobEvents(clientset, []watch.Event{{Type: watch.Modified, Object: tt.job}})
+
+			// Long enough to watch the event before timing out.
+			config := testJobConfig()
+			config.Timeout = "2s"
+
+			if _, err := sut.CreateCleanupJob(context.Background(), config); err == nil || !strings.Contains(err.Error(), "Timeout") {
+				t.Errorf("CreateCleanupJob() should keep waiting for an unfinished job until the timeout, got %v", err)
+			}
+		})
+	}
+}
+
+func Test_CreateCleanupJobFailureLogs(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "cleanup-test-1-abcde", Namespace: testJobNamespace, Labels: map[string]string{"job-name": "cleanup-test-1"}},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: external.TeardownContainerName}}},
+	}
+	sut, clientset := kubernetesTestSetup(pod)
+	watchJobEvents(clientset, []watch.Event{{Type: watch.Modified, Object: jobWithCondition(batchv1.JobFailed)}})
+
+	_, err := sut.CreateCleanupJob(context.Background(), testJobConfig())
+
+	if err == nil {
+		t.Fatalf("CreateCleanupJob() should error for a failed job")
+	}
+
+	// The fake clientset returns "fake logs" for every container.
+	want := "--- cleanup-test-1-abcde/" + external.TeardownContainerName + " ---\nfake logs"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("CreateCleanupJob() should add the container logs to the error, want %q in %q", want, err)
+	}
+}
+
+func Test_CreateCleanupJobTimeout(t *testing.T) {
+	sut, clientset := kubernetesTestSetup()
+	watchJobEvents(clientset)
+
+	config := testJobConfig()
+	config.Timeout = "1s"
+
+	start := time.Now()
+	_, err := sut.CreateCleanupJob(context.Background(), config)
+
+	if err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Errorf("CreateCleanupJob() should time out, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("CreateCleanupJob() should stop watching after the timeout, took %s", elapsed)
+	}
+}
+
+func Test_CreateCleanupJobCancelled(t *testing.T) {
+	sut, clientset := kubernetesTestSetup()
+	watchJobEvents(clientset)
+
+	config := testJobConfig()
+	config.Timeout = "1m"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := sut.CreateCleanupJob(ctx, config)
+
+	if errors.Cause(err) != context.DeadlineExceeded {
+		t.Errorf("CreateCleanupJob() should stop with the context's error, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("CreateCleanupJob() should stop watching once the context is done, took %s", elapsed)
+	}
+}
+
+func Test_CreateCleanupJobExistingJob(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     external.ExistingJobPolicy
+		existing   *batchv1.Job
+		wantAction external.CleanupJobAction
+		wantCreate bool
+	}{
+		{name: "reuse succeeded job", policy: external.ExistingJobReuse, existing: jobWithCondition(batchv1.JobComplete), wantAction: external.CleanupJobReused},
+		{name: "reuse running job", policy: external.ExistingJobReuse, existing: jobWithStatus(nil, nil, batchv1.JobStatus{Active: 1}), wantAction: external.CleanupJobReused},
+		{name: "reuse replaces failed job", policy: external.ExistingJobReuse, existing: jobWithCondition(batchv1.JobFailed), wantAction: external.CleanupJobReplaced, wantCreate: true},
+		{name: "skip", policy: external.ExistingJobSkip, existing: jobWithCondition(batchv1.JobFailed), wantAction: external.CleanupJobSkipped},
+		{name: "replace", policy: external.ExistingJobReplace, existing: jobWithCondition(batchv1.JobComplete), wantAction: external.CleanupJobReplaced, wantCreate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, clientset := kubernetesTestSetup(tt.existing)
+			watchJobEvents(clientset, []watch.Event{{Type: watch.Modified, Object: jobWithCondition(batchv1.JobComplete)}})
+
+			config := testJobConfig()
+			config.ExistingJobPolicy = tt.policy
+
+			action, err := sut.CreateCleanupJob(context.Background(), config)
+
+			if err != nil {
+				t.Fatalf("CreateCleanupJob() should not error, got %s", err)
+			}
+
+			if action != tt.wantAction {
+				t.Errorf("CreateCleanupJob() should return %s, got %s", tt.wantAction, action)
+			}
+
+			created := false
+			for _, action := range clientset.Actions() {
+				created = created || (action.GetVerb() == "create" && action.GetResource().Resource == "jobs")
+			}
+
+			if created != tt.wantCreate {
+				t.Errorf("CreateCleanupJob() should create a job: %t, got %t", tt.wantCreate, created)
+			}
+		})
+	}
+}
+
+func Test_GetNamespaces(t *testing.T) {
+	sut, _ := kubernetesTestSetup(
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature-1", Labels: map[string]string{"dev.centeva.meta": "PullRequest"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature-2", Labels: map[string]string{"dev.centeva.meta": "PullRequest"}}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+	)
+
+	namespaces, err := sut.GetNamespaces(context.Background(), "dev.centeva.meta=PullRequest")
+
+	if err != nil {
+		t.Fatalf("GetNamespaces() should not error, got %s", err)
+	}
+
+	if strings.Join(namespaces, ",") != "feature-1,feature-2" {
+		t.Errorf("GetNamespaces() should only return labelled namespaces, got %v", namespaces)
+	}
+
+	if all, _ := sut.GetNamespaces(context.Background(), ""); len(all) != 3 {
+		t.Errorf("GetNamespaces() without a label should return every namespace, got %v", all)
+	}
+}
+
+func Test_WaitForNamespaceDeleted(t *testing.T) {
+	sut, clientset := kubernetesTestSetup(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature-1"}})
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		clientset.CoreV1().Namespaces().Delete(context.Background(), "feature-1", metav1.DeleteOptions{})
+	}()
+
+	if err := sut.WaitForNamespaceDeleted(context.Background(), "feature-1", 5*time.Second); err != nil {
+		t.Errorf("WaitForNamespaceDeleted() should return once the namespace is deleted, got %s", err)
+	}
+
+	if err := sut.WaitForNamespaceDeleted(context.Background(), "missing", time.Second); err != nil {
+		t.Errorf("WaitForNamespaceDeleted() should not error for a missing namespace, got %s", err)
+	}
+}
+
+func Test_WaitForNamespaceDeletedTimeout(t *testing.T) {
+	sut, _ := kubernetesTestSetup(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "feature-1"}})
+
+	err := sut.WaitForNamespaceDeleted(context.Background(), "feature-1", time.Second)
+
+	if errors.Cause(err) != external.ErrNamespaceDeleteTimeout {
+		t.Errorf("WaitForNamespaceDeleted() should time out, got %v", err)
+	}
+}
+
+func Test_ScaleDownWorkloads(t *testing.T) {
+	replicas := int32(2)
+	sut, clientset := kubernetesTestSetup(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "feature-1"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	})
+
+	if err := sut.ScaleDownWorkloads(context.Background(), "feature-1"); err != nil {
+		t.Fatalf("ScaleDownWorkloads() should not error, got %s", err)
+	}
+
+	deployment, err := clientset.AppsV1().Deployments("feature-1").Get(context.Background(), "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get deployment: %s", err)
+	}
+
+	if *deployment.Spec.Replicas != 0 {
+		t.Errorf("ScaleDownWorkloads() should scale deployments to 0, got %d", *deployment.Spec.Replicas)
+	}
+}
@@ -0,0 +1,163 @@
+package external
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"strconv"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+// MySQLManager manages MySQL and MariaDB databases, its connection string is a go-sql-driver DSN such as
+// user:password@tcp(mysql:3306)/ optionally prefixed with mysql:// or mariadb://
+type MySQLManager struct {
+	db *sql.DB
+}
+
+func NewMySQLManager() *MySQLManager {
+	return &MySQLManager{}
+}
+
+// NewMySQLManagerForDB uses an existing connection pool instead of connecting with Connect, such as a stand-in in tests.
+func NewMySQLManagerForDB(db *sql.DB) *MySQLManager {
+	return &MySQLManager{
+		db: db,
+	}
+}
+
+func (m *MySQLManager) Close(ctx context.Context) {
+	if m.db != nil {
+		m.db.Close()
+	}
+}
+
+func (m *MySQLManager) Connect(ctx context.Context, connectionString string) (err error) {
+	dsn := strings.TrimPrefix(strings.TrimPrefix(connectionString, "mysql://"), "mariadb://")
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to database")
+	}
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return errors.Wrap(err, "Failed to connect to database")
+	}
+
+	m.db = db
+	return
+}
+
+func (m *MySQLManager) TerminateConnections(ctx context.Context, database string) (err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return err
+	}
+
+	ids, err := queryStrings(ctx, m.db, "SELECT id FROM information_schema.processlist WHERE db = ? AND id <> CONNECTION_ID()", database)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to close database connections for %s", database)
+	}
+
+	for _, id := range ids {
+		processID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// The connection may have closed since it was listed.
+		m.db.ExecContext(ctx, "KILL ?", processID)
+	}
+
+	return
+}
+
+// DeleteDatabase terminates the connections to the database and drops it. A missing database isn't an error, dropped
+// reports whether there was one.
+func (m *MySQLManager) DeleteDatabase(ctx context.Context, database string) (dropped bool, err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return false, err
+	}
+
+	exists, err := m.exists(ctx, database)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	if err = m.TerminateConnections(ctx, database); err != nil {
+		return false, err
+	}
+
+	if _, err = m.db.ExecContext(ctx, "DROP DATABASE IF EXISTS "+quoteMySQLIdentifier(database)); err != nil {
+		return false, errors.Wrapf(err, "Failed to delete database %s", database)
+	}
+
+	return true, nil
+}
+
+// ListDatabases returns the names of the databases other than the system schemas and the database of the connection.
+func (m *MySQLManager) ListDatabases(ctx context.Context) (databases []string, err error) {
+	listDatabases := `SELECT schema_name FROM information_schema.schemata
+	WHERE schema_name NOT IN ('information_schema', 'mysql', 'performance_schema', 'sys')
+		and schema_name <> COALESCE(DATABASE(), '')
+	ORDER BY schema_name`
+
+	if databases, err = queryStrings(ctx, m.db, listDatabases); err != nil {
+		return nil, errors.Wrap(err, "Failed to list databases")
+	}
+
+	return
+}
+
+func (m *MySQLManager) CreateDatabase(ctx context.Context, database string, options CreateDatabaseOptions) (created bool, err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return false, err
+	}
+
+	if options.Template != "" {
+		return false, notSupported("Creating a database from a template", "MySQL")
+	}
+
+	if options.Owner != "" {
+		return false, notSupported("A database owner", "MySQL")
+	}
+
+	exists, err := m.exists(ctx, database)
+	if err != nil || exists {
+		return false, err
+	}
+
+	if _, err = m.db.ExecContext(ctx, "CREATE DATABASE "+quoteMySQLIdentifier(database)); err != nil {
+		return false, errors.Wrapf(err, "Failed to create database %s", database)
+	}
+
+	return true, nil
+}
+
+func (m *MySQLManager) exists(ctx context.Context, database string) (bool, error) {
+	names, err := queryStrings(ctx, m.db, "SELECT schema_name FROM information_schema.schemata WHERE schema_name = ?", database)
+
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to check for database %s", database)
+	}
+
+	return len(names) > 0, nil
+}
+
+func (m *MySQLManager) CreateRole(ctx context.Context, role string, password string) (created bool, err error) {
+	return false, notSupported("Creating a role", "MySQL")
+}
+
+func (m *MySQLManager) DropRole(ctx context.Context, role string) (err error) {
+	return notSupported("Dropping a role", "MySQL")
+}
+
+func (m *MySQLManager) BackupDatabase(ctx context.Context, database string, w io.Writer) (err error) {
+	return notSupported("Backing up a database", "MySQL")
+}
+
+func quoteMySQLIdentifier(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
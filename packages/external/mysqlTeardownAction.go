@@ -0,0 +1,33 @@
+package external
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// mysqlTeardownAction drops a MySQL or MariaDB database, connectionString is a go-sql-driver DSN such as
+// user:password@tcp(mysql:3306)/
+type mysqlTeardownAction struct {
+	config TeardownActionConfig
+}
+
+func newMySQLTeardownAction(config TeardownActionConfig) (*mysqlTeardownAction, error) {
+	if err := requireSettings(map[string]string{"connectionString": config.ConnectionString}); err != nil {
+		return nil, err
+	}
+
+	return &mysqlTeardownAction{config: config}, nil
+}
+
+func (a *mysqlTeardownAction) Teardown(ctx context.Context, name string) (err error) {
+	mysqlManager := NewMySQLManager()
+
+	if err = mysqlManager.Connect(ctx, a.config.ConnectionString); err != nil {
+		return errors.Wrap(err, "Failed to open mysql connection")
+	}
+	defer mysqlManager.Close(ctx)
+
+	_, err = mysqlManager.DeleteDatabase(ctx, expandName(a.config.Database, NamePlaceholder, name))
+	return err
+}
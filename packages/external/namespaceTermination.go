@@ -0,0 +1,226 @@
+package external
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+)
+
+// ErrNamespaceDeleteTimeout is the cause of the error WaitForNamespaceDeleted returns when the namespace outlives the timeout.
+var ErrNamespaceDeleteTimeout = errors.New("timed out waiting for namespace to be deleted")
+
+// NamespaceStatus describes what keeps a terminating namespace around.
+type NamespaceStatus struct {
+	Phase v1.NamespacePhase
+	// Finalizers are the namespace's own spec.finalizers, removed by kubernetes once the namespace is empty.
+	Finalizers []string
+	// Conditions are the messages of the namespace's true conditions such as NamespaceFinalizersRemaining.
+	Conditions []string
+	Resources  []NamespaceResource
+}
+
+// NamespaceResource is a resource still left in a namespace.
+type NamespaceResource struct {
+	Kind       string
+	Name       string
+	Namespace  string
+	Finalizers []string
+
+	resource schema.GroupVersionResource
+}
+
+func (r NamespaceResource) String() string {
+	if len(r.Finalizers) == 0 {
+		return fmt.Sprintf("%s/%s", r.Kind, r.Name)
+	}
+
+	return fmt.Sprintf("%s/%s (finalizers: %s)", r.Kind, r.Name, strings.Join(r.Finalizers, ", "))
+}
+
+// WaitForNamespaceDeleted watches the namespace until it is gone or the timeout passes.
+func (k *KubernetesManager) WaitForNamespaceDeleted(ctx context.Context, namespace string, timeout time.Duration) (err error) {
+	deadline := time.Now().Add(timeout)
+	namespaces := k.clientset.CoreV1().Namespaces()
+
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return errors.Wrapf(ErrNamespaceDeleteTimeout, "Namespace %s still exists after %s", namespace, timeout)
+		}
+
+		current, err := namespaces.Get(ctx, namespace, metav1.GetOptions{})
+
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to get namespace %s", namespace)
+		}
+
+		timeoutSeconds := int64(remaining.Seconds()) + 1
+		watcher, err := namespaces.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", namespace).String(),
+			ResourceVersion: current.ResourceVersion,
+			TimeoutSeconds:  &timeoutSeconds,
+		})
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to watch namespace %s", namespace)
+		}
+
+		deleted, err := waitForDeletedEvent(ctx, watcher, deadline)
+		watcher.Stop()
+
+		if err != nil || deleted {
+			return err
+		}
+	}
+}
+
+// waitForDeletedEvent returns when the namespace is deleted, the watch ends, the deadline passes or ctx is done.
+func waitForDeletedEvent(ctx context.Context, watcher watch.Interface, deadline time.Time) (deleted bool, err error) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok || event.Type == watch.Error {
+				return false, nil
+			}
+
+			if event.Type == watch.Deleted {
+				return true, nil
+			}
+		case <-timer.C:
+			return false, nil
+		case <-ctx.Done():
+			return false, errors.Wrap(ctx.Err(), "Stopped waiting for namespace")
+		}
+	}
+}
+
+// GetNamespaceStatus lists the finalizers, conditions and resources of a namespace, a nil status means the
+// namespace is gone.
+func (k *KubernetesManager) GetNamespaceStatus(ctx context.Context, namespace string) (status *NamespaceStatus, err error) {
+	current, err := k.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to get namespace %s", namespace)
+	}
+
+	status = &NamespaceStatus{Phase: current.Status.Phase}
+
+	for _, finalizer := range current.Spec.Finalizers {
+		status.Finalizers = append(status.Finalizers, string(finalizer))
+	}
+
+	for _, condition := range current.Status.Conditions {
+		if condition.Status == v1.ConditionTrue {
+			status.Conditions = append(status.Conditions, fmt.Sprintf("%s: %s", condition.Type, condition.Message))
+		}
+	}
+
+	status.Resources, err = k.listNamespaceResources(ctx, namespace)
+	return
+}
+
+// listNamespaceResources lists every listable resource in the namespace, resources that can't be listed are skipped.
+func (k *KubernetesManager) listNamespaceResources(ctx context.Context, namespace string) (resources []NamespaceResource, err error) {
+	lists, err := k.clientset.Discovery().ServerPreferredNamespacedResources()
+
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, errors.Wrap(err, "Failed to discover namespaced resources")
+	}
+
+	for _, list := range lists {
+		groupVersion, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, apiResource := range list.APIResources {
+			if strings.Contains(apiResource.Name, "/") || apiResource.Name == "events" || !hasVerb(apiResource.Verbs, "list") {
+				continue
+			}
+
+			gvr := groupVersion.WithResource(apiResource.Name)
+			items, err := k.dynamicClient.Resource(gvr).Namespace(namespace).List(ctx, metav1.ListOptions{})
+
+			if err != nil {
+				log.Printf("Failed to list %s in %s: %s", gvr.String(), namespace, err)
+				continue
+			}
+
+			for _, item := range items.Items {
+				resources = append(resources, NamespaceResource{
+					Kind:       apiResource.Kind,
+					Name:       item.GetName(),
+					Namespace:  namespace,
+					Finalizers: item.GetFinalizers(),
+					resource:   gvr,
+				})
+			}
+		}
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].String() < resources[j].String()
+	})
+
+	return resources, nil
+}
+
+// RemoveFinalizers clears the finalizers of the resources so a terminating namespace can finish deleting them.
+// Whatever the finalizers were meant to clean up outside the cluster is left behind.
+func (k *KubernetesManager) RemoveFinalizers(ctx context.Context, resources []NamespaceResource) (err error) {
+	removeFinalizers := []byte(`{"metadata":{"finalizers":null}}`)
+
+	for _, resource := range resources {
+		if len(resource.Finalizers) == 0 {
+			continue
+		}
+
+		_, err = k.dynamicClient.Resource(resource.resource).Namespace(resource.Namespace).Patch(ctx, resource.Name, types.MergePatchType, removeFinalizers, metav1.PatchOptions{})
+
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "Failed to remove finalizers from %s/%s", resource.Kind, resource.Name)
+		}
+
+		log.Printf("Removed finalizers %s from %s/%s", strings.Join(resource.Finalizers, ", "), resource.Kind, resource.Name)
+	}
+
+	return
+}
+
+func hasVerb(verbs metav1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,80 @@
+package external
+
+import (
+	"context"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+// objectStorageTeardownAction removes every object under prefix, {name}/ by default, from an S3 compatible bucket.
+type objectStorageTeardownAction struct {
+	config TeardownActionConfig
+}
+
+func newObjectStorageTeardownAction(config TeardownActionConfig) (*objectStorageTeardownAction, error) {
+	if err := requireSettings(map[string]string{"address": config.Address, "bucket": config.Bucket}); err != nil {
+		return nil, err
+	}
+
+	return &objectStorageTeardownAction{config: config}, nil
+}
+
+func (a *objectStorageTeardownAction) Teardown(ctx context.Context, name string) (err error) {
+	prefix := expandName(a.config.Prefix, NamePlaceholder+"/", name)
+
+	creds := credentials.NewIAM("")
+	if a.config.AccessKey != "" {
+		creds = credentials.NewStaticV4(a.config.AccessKey, a.config.SecretKey, "")
+	}
+
+	client, err := minio.New(a.config.Address, &minio.Options{
+		Creds:  creds,
+		Secure: !a.config.Insecure,
+		Region: a.config.Region,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Failed to create object storage client")
+	}
+
+	// Cancelled once RemoveObjects returns, so the listing stops even when RemoveObjects stopped reading early.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := client.ListObjects(ctx, a.config.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+	toRemove := make(chan minio.ObjectInfo)
+	listErr := make(chan error, 1)
+
+	go func() {
+		defer close(toRemove)
+		for object := range objects {
+			if object.Err != nil {
+				listErr <- errors.Wrapf(object.Err, "Failed to list objects under %s", prefix)
+				return
+			}
+
+			select {
+			case toRemove <- object:
+			case <-ctx.Done():
+				listErr <- errors.Wrapf(ctx.Err(), "Stopped removing objects under %s", prefix)
+				return
+			}
+		}
+		listErr <- nil
+	}()
+
+	for removeErr := range client.RemoveObjects(ctx, a.config.Bucket, toRemove, minio.RemoveObjectsOptions{}) {
+		if err == nil {
+			err = errors.Wrapf(removeErr.Err, "Failed to remove %s", removeErr.ObjectName)
+		}
+	}
+
+	cancel()
+
+	if listErr := <-listErr; listErr != nil && err == nil {
+		err = listErr
+	}
+
+	return
+}
@@ -1,62 +1,524 @@
 package external
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/pkg/errors"
 )
 
-type IPostgresManager interface {
-	Connect(connectionString string) (err error)
-	DeleteDatabase(database string) (err error)
-	Close()
+// PostgresConnection is the part of *pgx.Conn the PostgresManager uses.
+type PostgresConnection interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	Close(ctx context.Context) error
 }
 
 type PostgresManager struct {
-	connection *pgx.Conn
-	ctx        context.Context
+	connection       PostgresConnection
+	connectionString string
 }
 
 func NewPostgresManager() *PostgresManager {
+	return &PostgresManager{}
+}
+
+// NewPostgresManagerForConnection uses an existing connection instead of connecting with Connect, such as a stand-in
+// in tests.
+func NewPostgresManagerForConnection(connection PostgresConnection) *PostgresManager {
 	return &PostgresManager{
-		ctx: context.Background(),
+		connection: connection,
 	}
 }
 
-func (p *PostgresManager) Close() {
-	defer p.connection.Close(p.ctx)
+// Close closes the connection, if Connect made one.
+func (p *PostgresManager) Close(ctx context.Context) {
+	if p.connection != nil {
+		p.connection.Close(ctx)
+	}
 }
 
-func (p *PostgresManager) Connect(connectionString string) (err error) {
-	conn, err := pgx.Connect(p.ctx, connectionString)
+// Connect connects within the deadline of ctx, if it has one, and sets the session's lock_timeout and
+// statement_timeout to the time left, so the server also gives up on a statement blocked on a lock.
+func (p *PostgresManager) Connect(ctx context.Context, connectionString string) (err error) {
+	config, err := PostgresConnConfig(ctx, connectionString)
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to database")
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, config)
 
 	if err != nil {
 		return errors.Wrap(err, "Failed to connect to database")
 	}
 	p.connection = conn
+	p.connectionString = connectionString
 	return
 }
 
-func (p *PostgresManager) DeleteDatabase(database string) (err error) {
+// PostgresConnConfig parses the connectionString and sets lock_timeout and statement_timeout to the time left before
+// the deadline of ctx, it sets neither when ctx has no deadline.
+func PostgresConnConfig(ctx context.Context, connectionString string) (*pgx.ConnConfig, error) {
+	config, err := pgx.ParseConfig(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return config, nil
+	}
+
+	timeout := time.Until(deadline).Milliseconds()
+	if timeout < 1 {
+		timeout = 1
+	}
+
+	config.RuntimeParams["lock_timeout"] = strconv.FormatInt(timeout, 10)
+	config.RuntimeParams["statement_timeout"] = strconv.FormatInt(timeout, 10)
+	return config, nil
+}
+
+func (p *PostgresManager) TerminateConnections(ctx context.Context, database string) (err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return err
+	}
+
 	closeDbConnections := `SELECT pg_terminate_backend(pg_stat_activity.pid)
 	FROM pg_stat_activity
-	WHERE pg_stat_activity.datname = '%s'
+	WHERE pg_stat_activity.datname = $1
 		and pid <> pg_backend_pid();`
-	deleteDb := `DROP DATABASE IF EXISTS "%s"`
 
-	_, err = p.connection.Exec(p.ctx, fmt.Sprintf(closeDbConnections, database))
+	_, err = p.connection.Exec(ctx, closeDbConnections, database)
 
 	if err != nil {
 		return errors.Wrapf(err, "Failed to close database connections for %s", database)
 	}
 
-	_, err = p.connection.Exec(p.ctx, fmt.Sprintf(deleteDb, database))
+	return
+}
+
+// DeleteDatabase revokes CONNECT from PUBLIC so apps can't reconnect, then drops the database WITH (FORCE) on
+// Postgres 13 and later, which terminates the remaining connections in the same statement. Older servers terminate
+// the connections first. The owner and superusers keep CONNECT, the forced drop or terminate still disconnects them.
+// A missing database isn't an error, dropped reports whether there was one.
+func (p *PostgresManager) DeleteDatabase(ctx context.Context, database string) (dropped bool, err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return false, err
+	}
+
+	exists, err := p.exists(ctx, "SELECT 1 FROM pg_database WHERE datname = $1;", database)
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to check for database %s", database)
+	}
+
+	if !exists {
+		return false, nil
+	}
+
+	if _, err = p.connection.Exec(ctx, "REVOKE CONNECT ON DATABASE "+pgx.Identifier{database}.Sanitize()+" FROM PUBLIC"); err != nil {
+		return false, errors.Wrapf(err, "Failed to revoke connect on database %s", database)
+	}
+
+	version, err := p.serverVersion(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	dropDb := "DROP DATABASE IF EXISTS " + pgx.Identifier{database}.Sanitize()
+
+	if version >= forceDropVersion {
+		dropDb += " WITH (FORCE)"
+	} else if err = p.TerminateConnections(ctx, database); err != nil {
+		return false, err
+	}
+
+	if _, err = p.connection.Exec(ctx, dropDb); err != nil {
+		return false, errors.Wrapf(err, "Failed to delete database %s", database)
+	}
+
+	return true, nil
+}
+
+// forceDropVersion is the server_version_num of Postgres 13, the first with DROP DATABASE ... WITH (FORCE).
+const forceDropVersion = 130000
+
+func (p *PostgresManager) serverVersion(ctx context.Context) (version int, err error) {
+	rows, err := p.connection.Query(ctx, "SHOW server_version_num;")
+
+	if err != nil {
+		return 0, errors.Wrap(err, "Failed to get server version")
+	}
+	defer rows.Close()
+
+	var versionNum string
+	if rows.Next() {
+		if err = rows.Scan(&versionNum); err != nil {
+			return 0, errors.Wrap(err, "Failed to get server version")
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, errors.Wrap(err, "Failed to get server version")
+	}
+
+	if version, err = strconv.Atoi(versionNum); err != nil {
+		return 0, errors.Wrapf(err, "Failed to parse server version '%s'", versionNum)
+	}
+
+	return
+}
+
+// ListDatabases returns the names of the databases other than templates and the database of the connection.
+func (p *PostgresManager) ListDatabases(ctx context.Context) (databases []string, err error) {
+	listDatabases := `SELECT datname FROM pg_database
+	WHERE datistemplate = false
+		and datname <> current_database()
+	ORDER BY datname;`
+
+	rows, err := p.connection.Query(ctx, listDatabases)
 
 	if err != nil {
-		return errors.Wrapf(err, "Failed to delete database %s", database)
+		return nil, errors.Wrap(err, "Failed to list databases")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var database string
+		if err = rows.Scan(&database); err != nil {
+			return nil, errors.Wrap(err, "Failed to list databases")
+		}
+		databases = append(databases, database)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "Failed to list databases")
 	}
 
 	return
 }
+
+// CreateDatabase creates the database unless it already exists, created is false when it did.
+func (p *PostgresManager) CreateDatabase(ctx context.Context, database string, options CreateDatabaseOptions) (created bool, err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return false, err
+	}
+
+	for _, name := range []string{options.Template, options.Owner} {
+		if name == "" {
+			continue
+		}
+
+		if err = ValidateDatabaseName(name); err != nil {
+			return false, err
+		}
+	}
+
+	exists, err := p.exists(ctx, "SELECT 1 FROM pg_database WHERE datname = $1;", database)
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to check for database %s", database)
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	createDb := "CREATE DATABASE " + pgx.Identifier{database}.Sanitize()
+
+	if options.Owner != "" {
+		createDb += " OWNER " + pgx.Identifier{options.Owner}.Sanitize()
+	}
+
+	if options.Template != "" {
+		createDb += " TEMPLATE " + pgx.Identifier{options.Template}.Sanitize()
+	}
+
+	if _, err = p.connection.Exec(ctx, createDb); err != nil {
+		return false, errors.Wrapf(err, "Failed to create database %s", database)
+	}
+
+	return true, nil
+}
+
+// roleComment marks the roles CreateRole created, collie doesn't change or drop a role without it.
+const roleComment = "Created by collie"
+
+const (
+	roleMissing   = ""
+	roleSuperuser = "superuser"
+	roleCurrent   = "current"
+	roleCreated   = "created"
+	roleUnmarked  = "unmarked"
+)
+
+// roleState returns whether the role is missing, a superuser, the connecting role, created by CreateRole or not.
+func (p *PostgresManager) roleState(ctx context.Context, role string) (state string, err error) {
+	rows, err := p.connection.Query(ctx, `SELECT CASE
+		WHEN rolsuper THEN '`+roleSuperuser+`'
+		WHEN rolname = current_user THEN '`+roleCurrent+`'
+		WHEN shobj_description(oid, 'pg_authid') = $2 THEN '`+roleCreated+`'
+		ELSE '`+roleUnmarked+`' END
+		FROM pg_roles WHERE rolname = $1;`, role, roleComment)
+
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err = rows.Scan(&state); err != nil {
+			return "", err
+		}
+	}
+
+	return state, rows.Err()
+}
+
+// CreateRole creates a login role, or sets the password of a role it created before, and reports whether it created the
+// role. An existing role that CreateRole didn't create, a superuser or the connecting role is left alone and is an error.
+func (p *PostgresManager) CreateRole(ctx context.Context, role string, password string) (created bool, err error) {
+	if err = ValidateDatabaseName(role); err != nil {
+		return false, err
+	}
+
+	state, err := p.roleState(ctx, role)
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to check for role %s", role)
+	}
+
+	if state != roleMissing && state != roleCreated {
+		return false, errors.Errorf("Role %s already exists and wasn't created by collie (%s)", role, state)
+	}
+
+	quotedRole := pgx.Identifier{role}.Sanitize()
+
+	// CREATE and ALTER ROLE don't take bound parameters, so the password is quoted instead. Without arguments Exec uses
+	// the simple protocol, which runs both statements in one implicit transaction, so there is never an unmarked role.
+	statement := "CREATE ROLE " + quotedRole + " LOGIN PASSWORD " + quoteLiteral(password) + "; " +
+		"COMMENT ON ROLE " + quotedRole + " IS " + quoteLiteral(roleComment)
+	if state == roleCreated {
+		statement = "ALTER ROLE " + quotedRole + " WITH LOGIN PASSWORD " + quoteLiteral(password)
+	}
+
+	if _, err = p.connection.Exec(ctx, statement); err != nil {
+		return false, errors.Wrapf(err, "Failed to create role %s", role)
+	}
+
+	return state == roleMissing, nil
+}
+
+// DropRole reassigns what the role owns in the database of the connection to the connecting role, drops its
+// privileges and then the role. Objects it owns in other databases must be dropped first, such as by DeleteDatabase.
+// Only a role created by CreateRole is dropped, any other role, a superuser or the connecting role is an error.
+func (p *PostgresManager) DropRole(ctx context.Context, role string) (err error) {
+	if err = ValidateDatabaseName(role); err != nil {
+		return err
+	}
+
+	state, err := p.roleState(ctx, role)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to check for role %s", role)
+	}
+
+	if state == roleMissing {
+		return
+	}
+
+	if state != roleCreated {
+		return errors.Errorf("Refusing to drop role %s, it wasn't created by collie (%s)", role, state)
+	}
+
+	quotedRole := pgx.Identifier{role}.Sanitize()
+
+	for _, statement := range []string{
+		"REASSIGN OWNED BY " + quotedRole + " TO CURRENT_USER",
+		"DROP OWNED BY " + quotedRole,
+		"DROP ROLE IF EXISTS " + quotedRole,
+	} {
+		if _, err = p.connection.Exec(ctx, statement); err != nil {
+			return errors.Wrapf(err, "Failed to drop role %s", role)
+		}
+	}
+
+	return
+}
+
+func (p *PostgresManager) exists(ctx context.Context, query string, arguments ...interface{}) (exists bool, err error) {
+	rows, err := p.connection.Query(ctx, query, arguments...)
+
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	exists = rows.Next()
+	return exists, rows.Err()
+}
+
+// BackupDatabase writes a pg_dump custom format archive of the database to w, restore it with pg_restore. pg_dump must
+// be on the PATH, it connects with the host, port, user, password and ssl settings of the connectionString given to Connect.
+func (p *PostgresManager) BackupDatabase(ctx context.Context, database string, w io.Writer) (err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return err
+	}
+
+	env, err := LibpqEnv(p.connectionString, database)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	dump := exec.CommandContext(ctx, "pg_dump", "--format=custom")
+	dump.Env = append(os.Environ(), env...)
+	dump.Stdout = w
+	dump.Stderr = &stderr
+
+	if err = dump.Run(); err != nil {
+		return errors.Wrapf(err, "Failed to back up database %s: %s", database, strings.TrimSpace(stderr.String()))
+	}
+
+	return
+}
+
+// LibpqEnv returns the PG* env variables pg_dump connects to the database with, so the password stays out of the
+// process list. The ssl settings are passed as they are in the connectionString, settings it leaves out keep libpq's
+// default or the PG* variable of collie's environment, as they do for collie's own connection.
+func LibpqEnv(connectionString string, database string) ([]string, error) {
+	config, err := pgconn.ParseConfig(connectionString)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse connectionString")
+	}
+
+	env := []string{
+		"PGHOST=" + config.Host,
+		"PGPORT=" + strconv.Itoa(int(config.Port)),
+		"PGUSER=" + config.User,
+		"PGPASSWORD=" + config.Password,
+		"PGDATABASE=" + database,
+	}
+
+	settings, err := connectionSettings(connectionString)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse connectionString")
+	}
+
+	for _, setting := range []string{"sslmode", "sslrootcert", "sslcert", "sslkey"} {
+		if value, ok := settings[setting]; ok {
+			env = append(env, "PG"+strings.ToUpper(setting)+"="+value)
+		}
+	}
+
+	return env, nil
+}
+
+// dsnSpace separates the keywords of a keyword/value connection string.
+const dsnSpace = " \t\n\r\v\f"
+
+// connectionSettings returns the query parameters of a url, or the keywords of a keyword/value connection string.
+func connectionSettings(connectionString string) (map[string]string, error) {
+	settings := make(map[string]string)
+
+	if strings.HasPrefix(connectionString, "postgres://") || strings.HasPrefix(connectionString, "postgresql://") {
+		parsed, err := url.Parse(connectionString)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, values := range parsed.Query() {
+			settings[key] = values[len(values)-1]
+		}
+
+		return settings, nil
+	}
+
+	for s := strings.TrimSpace(connectionString); s != ""; s = strings.TrimSpace(s) {
+		eq := strings.IndexRune(s, '=')
+		if eq < 0 {
+			return nil, errors.New("invalid keyword/value")
+		}
+
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], dsnSpace)
+
+		var value strings.Builder
+		quoted := strings.HasPrefix(s, "'")
+		if quoted {
+			s = s[1:]
+		}
+
+		i := 0
+		for ; i < len(s); i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+			} else if (quoted && s[i] == '\'') || (!quoted && strings.IndexByte(dsnSpace, s[i]) >= 0) {
+				break
+			}
+			value.WriteByte(s[i])
+		}
+
+		if quoted && i == len(s) {
+			return nil, errors.New("unterminated quoted string")
+		}
+
+		settings[key] = value.String()
+		if i < len(s) {
+			s = s[i+1:]
+		} else {
+			s = ""
+		}
+	}
+
+	return settings, nil
+}
+
+// RoleConnectionString is the connectionString with the user, password and database replaced, for the Secret of a
+// role created by CreateRole. The host, port and parameters such as sslmode are kept.
+func RoleConnectionString(connectionString string, role string, password string, database string) (string, error) {
+	if _, err := pgconn.ParseConfig(connectionString); err != nil {
+		return "", errors.Wrap(err, "Failed to parse connectionString")
+	}
+
+	if !strings.HasPrefix(connectionString, "postgres://") && !strings.HasPrefix(connectionString, "postgresql://") {
+		// In a keyword/value connection string a later keyword wins over an earlier one.
+		return fmt.Sprintf("%s user=%s password=%s dbname=%s", connectionString, quoteDSNValue(role), quoteDSNValue(password), quoteDSNValue(database)), nil
+	}
+
+	roleUrl, err := url.Parse(connectionString)
+	if err != nil {
+		return "", errors.Wrap(err, "Failed to parse connectionString")
+	}
+
+	// Query parameters win over the user and path, so they are removed.
+	query := roleUrl.Query()
+	for _, param := range []string{"user", "password", "dbname", "database"} {
+		query.Del(param)
+	}
+
+	roleUrl.User = url.UserPassword(role, password)
+	roleUrl.Path = "/" + database
+	roleUrl.RawPath = ""
+	roleUrl.RawQuery = query.Encode()
+
+	return roleUrl.String(), nil
+}
+
+// quoteDSNValue quotes a value of a keyword/value connection string.
+func quoteDSNValue(value string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), "'", `\'`) + "'"
+}
+
+// quoteLiteral quotes a string constant, as an escape string so it is read the same with any standard_conforming_strings.
+func quoteLiteral(value string) string {
+	return "E'" + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), "'", "''") + "'"
+}
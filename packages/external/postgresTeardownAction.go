@@ -0,0 +1,30 @@
+package external
+
+import (
+	"context"
+)
+
+// postgresTeardownAction drops a database on a Postgres server other than the one Teardown always cleans up.
+type postgresTeardownAction struct {
+	config TeardownActionConfig
+}
+
+func newPostgresTeardownAction(config TeardownActionConfig) (*postgresTeardownAction, error) {
+	if err := requireSettings(map[string]string{"connectionString": config.ConnectionString}); err != nil {
+		return nil, err
+	}
+
+	return &postgresTeardownAction{config: config}, nil
+}
+
+func (a *postgresTeardownAction) Teardown(ctx context.Context, name string) (err error) {
+	postgresManager := NewPostgresManager()
+
+	if err = postgresManager.Connect(ctx, a.config.ConnectionString); err != nil {
+		return err
+	}
+	defer postgresManager.Close(ctx)
+
+	_, err = postgresManager.DeleteDatabase(ctx, expandName(a.config.Database, NamePlaceholder, name))
+	return err
+}
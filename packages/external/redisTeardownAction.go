@@ -0,0 +1,78 @@
+package external
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/pkg/errors"
+)
+
+// redisTeardownAction deletes every key starting with prefix, {name}: by default.
+type redisTeardownAction struct {
+	config TeardownActionConfig
+}
+
+func newRedisTeardownAction(config TeardownActionConfig) (*redisTeardownAction, error) {
+	if err := requireSettings(map[string]string{"address": config.Address}); err != nil {
+		return nil, err
+	}
+
+	return &redisTeardownAction{config: config}, nil
+}
+
+func (a *redisTeardownAction) Teardown(ctx context.Context, name string) (err error) {
+	prefix := expandName(a.config.Prefix, NamePlaceholder+":", name)
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     a.config.Address,
+		Password: a.config.Password,
+		DB:       a.config.DB,
+	})
+	defer client.Close()
+
+	iter := client.Scan(ctx, 0, escapeRedisPattern(prefix)+"*", 1000).Iterator()
+
+	var keys []string
+	deleted := 0
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+
+		if err := client.Unlink(ctx, keys...).Err(); err != nil {
+			return errors.Wrapf(err, "Failed to delete keys with prefix %s", prefix)
+		}
+
+		deleted += len(keys)
+		keys = keys[:0]
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+
+		if len(keys) >= 1000 {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = iter.Err(); err != nil {
+		return errors.Wrapf(err, "Failed to scan keys with prefix %s", prefix)
+	}
+
+	if err = flush(); err != nil {
+		return err
+	}
+
+	log.Printf("Deleted %d redis keys with prefix %s", deleted, prefix)
+	return
+}
+
+// escapeRedisPattern escapes the glob characters in a SCAN MATCH pattern.
+func escapeRedisPattern(pattern string) string {
+	return strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`).Replace(pattern)
+}
@@ -0,0 +1,210 @@
+package external
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"strings"
+	"time"
+
+	_ "github.com/denisenkom/go-mssqldb"
+	"github.com/pkg/errors"
+)
+
+// SQLServerManager manages SQL Server databases, its connection string is a sqlserver:// url.
+type SQLServerManager struct {
+	db *sql.DB
+}
+
+func NewSQLServerManager() *SQLServerManager {
+	return &SQLServerManager{}
+}
+
+// NewSQLServerManagerForDB uses an existing connection pool instead of connecting with Connect, such as a stand-in in
+// tests.
+func NewSQLServerManagerForDB(db *sql.DB) *SQLServerManager {
+	return &SQLServerManager{
+		db: db,
+	}
+}
+
+func (s *SQLServerManager) Close(ctx context.Context) {
+	if s.db != nil {
+		s.db.Close()
+	}
+}
+
+func (s *SQLServerManager) Connect(ctx context.Context, connectionString string) (err error) {
+	db, err := sql.Open("sqlserver", connectionString)
+	if err != nil {
+		return errors.Wrap(err, "Failed to connect to database")
+	}
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return errors.Wrap(err, "Failed to connect to database")
+	}
+
+	s.db = db
+	return
+}
+
+// multiUserTimeout bounds setting a database back to multi user mode after its drop failed, which is also done when
+// the drop ran out of time.
+const multiUserTimeout = 30 * time.Second
+
+// TerminateConnections rolls back the open transactions of the database and closes its connections, then allows
+// connections again.
+func (s *SQLServerManager) TerminateConnections(ctx context.Context, database string) (err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return err
+	}
+
+	exists, err := s.exists(ctx, database)
+	if err != nil || !exists {
+		return err
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to close database connections for %s", database)
+	}
+	defer conn.Close()
+
+	if err = singleUser(ctx, conn, database); err != nil {
+		return err
+	}
+
+	return multiUser(conn, database)
+}
+
+// DeleteDatabase sets the database to single user mode and drops it on the same connection, so no other connection
+// can take the single user in between. When the drop fails the database is set back to multi user mode. A missing
+// database isn't an error, dropped reports whether there was one.
+func (s *SQLServerManager) DeleteDatabase(ctx context.Context, database string) (dropped bool, err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return false, err
+	}
+
+	exists, err := s.exists(ctx, database)
+	if err != nil {
+		return false, err
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to delete database %s", database)
+	}
+	defer conn.Close()
+
+	if exists {
+		if err = singleUser(ctx, conn, database); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err = conn.ExecContext(ctx, "DROP DATABASE IF EXISTS "+quoteSQLServerIdentifier(database)); err != nil {
+		err = errors.Wrapf(err, "Failed to delete database %s", database)
+
+		if exists {
+			if restoreErr := multiUser(conn, database); restoreErr != nil {
+				err = errors.Wrap(err, restoreErr.Error())
+			}
+		}
+
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func singleUser(ctx context.Context, conn *sql.Conn, database string) (err error) {
+	if _, err = conn.ExecContext(ctx, "ALTER DATABASE "+quoteSQLServerIdentifier(database)+" SET SINGLE_USER WITH ROLLBACK IMMEDIATE"); err != nil {
+		return errors.Wrapf(err, "Failed to close database connections for %s", database)
+	}
+
+	return
+}
+
+func multiUser(conn *sql.Conn, database string) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), multiUserTimeout)
+	defer cancel()
+
+	if _, err = conn.ExecContext(ctx, "ALTER DATABASE "+quoteSQLServerIdentifier(database)+" SET MULTI_USER"); err != nil {
+		return errors.Wrapf(err, "Failed to set database %s back to multi user", database)
+	}
+
+	return
+}
+
+// ListDatabases returns the names of the databases other than the system databases and the database of the connection.
+func (s *SQLServerManager) ListDatabases(ctx context.Context) (databases []string, err error) {
+	listDatabases := "SELECT name FROM sys.databases WHERE database_id > 4 and name <> DB_NAME() ORDER BY name"
+
+	if databases, err = queryStrings(ctx, s.db, listDatabases); err != nil {
+		return nil, errors.Wrap(err, "Failed to list databases")
+	}
+
+	return
+}
+
+func (s *SQLServerManager) CreateDatabase(ctx context.Context, database string, options CreateDatabaseOptions) (created bool, err error) {
+	if err = ValidateDatabaseName(database); err != nil {
+		return false, err
+	}
+
+	if options.Template != "" {
+		return false, notSupported("Creating a database from a template", "SQL Server")
+	}
+
+	if options.Owner != "" {
+		if err = ValidateDatabaseName(options.Owner); err != nil {
+			return false, errors.Wrap(err, "Invalid owner")
+		}
+	}
+
+	exists, err := s.exists(ctx, database)
+	if err != nil || exists {
+		return false, err
+	}
+
+	if _, err = s.db.ExecContext(ctx, "CREATE DATABASE "+quoteSQLServerIdentifier(database)); err != nil {
+		return false, errors.Wrapf(err, "Failed to create database %s", database)
+	}
+
+	if options.Owner != "" {
+		changeOwner := "ALTER AUTHORIZATION ON DATABASE::" + quoteSQLServerIdentifier(database) + " TO " + quoteSQLServerIdentifier(options.Owner)
+
+		if _, err = s.db.ExecContext(ctx, changeOwner); err != nil {
+			return true, errors.Wrapf(err, "Failed to change owner of database %s", database)
+		}
+	}
+
+	return true, nil
+}
+
+func (s *SQLServerManager) CreateRole(ctx context.Context, role string, password string) (created bool, err error) {
+	return false, notSupported("Creating a role", "SQL Server")
+}
+
+func (s *SQLServerManager) DropRole(ctx context.Context, role string) (err error) {
+	return notSupported("Dropping a role", "SQL Server")
+}
+
+func (s *SQLServerManager) BackupDatabase(ctx context.Context, database string, w io.Writer) (err error) {
+	return notSupported("Backing up a database", "SQL Server")
+}
+
+func (s *SQLServerManager) exists(ctx context.Context, database string) (bool, error) {
+	names, err := queryStrings(ctx, s.db, "SELECT name FROM sys.databases WHERE name = @p1", database)
+
+	if err != nil {
+		return false, errors.Wrapf(err, "Failed to check for database %s", database)
+	}
+
+	return len(names) > 0, nil
+}
+
+func quoteSQLServerIdentifier(identifier string) string {
+	return "[" + strings.ReplaceAll(identifier, "]", "]]") + "]"
+}
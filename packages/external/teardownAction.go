@@ -0,0 +1,153 @@
+package external
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ITeardownAction removes one kind of resource a preview environment created besides its namespace and database.
+type ITeardownAction interface {
+	Teardown(ctx context.Context, name string) (err error)
+}
+
+type TeardownActionType string
+
+const (
+	TeardownActionPostgres      TeardownActionType = "postgres"
+	TeardownActionMySQL         TeardownActionType = "mysql"
+	TeardownActionRedis         TeardownActionType = "redis"
+	TeardownActionObjectStorage TeardownActionType = "objectStorage"
+	TeardownActionDNS           TeardownActionType = "dns"
+	TeardownActionHelm          TeardownActionType = "helm"
+)
+
+const (
+	// TeardownActionsEnv is read by Teardown when --ActionsFile is not set.
+	TeardownActionsEnv = "COLLIE_TEARDOWN_ACTIONS"
+	// TeardownActionsKey is the key used in Secrets created for the teardown actions.
+	TeardownActionsKey = "actions"
+	// NamePlaceholder is replaced with the cleaned branch name in teardown action settings.
+	NamePlaceholder = "{name}"
+)
+
+// TeardownActionConfig configures one teardown action, only the settings of its type are used. Settings that name
+// a resource may contain {name} which is replaced with the cleaned branch name.
+type TeardownActionConfig struct {
+	Type    TeardownActionType `yaml:"type"`
+	Name    string             `yaml:"name,omitempty"`
+	Timeout string             `yaml:"timeout,omitempty"`
+
+	// postgres, mysql
+	ConnectionString string `yaml:"connectionString,omitempty"`
+	Database         string `yaml:"database,omitempty"`
+
+	// redis, objectStorage, dns
+	Address  string `yaml:"address,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	DB       int    `yaml:"db,omitempty"`
+	Prefix   string `yaml:"prefix,omitempty"`
+
+	// objectStorage
+	Bucket    string `yaml:"bucket,omitempty"`
+	Region    string `yaml:"region,omitempty"`
+	AccessKey string `yaml:"accessKey,omitempty"`
+	SecretKey string `yaml:"secretKey,omitempty"`
+	Insecure  bool   `yaml:"insecure,omitempty"`
+
+	// dns
+	Zone          string `yaml:"zone,omitempty"`
+	Record        string `yaml:"record,omitempty"`
+	TSIGKey       string `yaml:"tsigKey,omitempty"`
+	TSIGSecret    string `yaml:"tsigSecret,omitempty"`
+	TSIGAlgorithm string `yaml:"tsigAlgorithm,omitempty"`
+
+	// helm
+	Release   string `yaml:"release,omitempty"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// BeforeNamespace reports whether the action runs before the namespace is deleted, because it needs what is in it. Helm
+// keeps its releases in Secrets in the release namespace, without them helm uninstall can't remove what the release
+// created outside the namespace.
+func (c TeardownActionConfig) BeforeNamespace() bool {
+	return c.Type == TeardownActionHelm
+}
+
+// StepName is the name the action is reported under, the type unless a name is set.
+func (c TeardownActionConfig) StepName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+
+	return string(c.Type)
+}
+
+// NewTeardownAction validates the config and returns the action for its type.
+func NewTeardownAction(config TeardownActionConfig) (action ITeardownAction, err error) {
+	switch config.Type {
+	case TeardownActionPostgres:
+		action, err = newPostgresTeardownAction(config)
+	case TeardownActionMySQL:
+		action, err = newMySQLTeardownAction(config)
+	case TeardownActionRedis:
+		action, err = newRedisTeardownAction(config)
+	case TeardownActionObjectStorage:
+		action, err = newObjectStorageTeardownAction(config)
+	case TeardownActionDNS:
+		action, err = newDNSTeardownAction(config)
+	case TeardownActionHelm:
+		action, err = newHelmTeardownAction(config)
+	default:
+		return nil, errors.Errorf("Unknown teardown action type '%s' must be one of [postgres|mysql|redis|objectStorage|dns|helm]", config.Type)
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid %s teardown action", config.StepName())
+	}
+
+	return
+}
+
+// ParseTeardownActions reads a yaml list of teardown actions, as stored in the cleanup job Secret.
+func ParseTeardownActions(file []byte) (actions []TeardownActionConfig, err error) {
+	if err = yaml.Unmarshal(file, &actions); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse teardown actions")
+	}
+
+	for _, config := range actions {
+		if _, err = NewTeardownAction(config); err != nil {
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// expandName replaces {name} in value, or returns def with {name} replaced when value is empty.
+func expandName(value string, def string, name string) string {
+	if value == "" {
+		value = def
+	}
+
+	return strings.ReplaceAll(value, NamePlaceholder, name)
+}
+
+func requireSettings(settings map[string]string) error {
+	var missing []string
+	for setting, value := range settings {
+		if value == "" {
+			missing = append(missing, setting)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return errors.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,554 @@
+package external_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/centeva/collie/packages/external"
+	"github.com/miekg/dns"
+)
+
+// fakeObjectStorage is an S3 bucket serving ListObjectsV2 and multi object delete.
+type fakeObjectStorage struct {
+	mu       sync.Mutex
+	objects  map[string]bool
+	prefixes []string
+	// deleteHandler replaces the multi object delete when set.
+	deleteHandler http.HandlerFunc
+}
+
+func (s *fakeObjectStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := r.URL.Query()["delete"]; ok && r.Method == http.MethodPost {
+		if s.deleteHandler != nil {
+			s.mu.Unlock()
+			s.deleteHandler(w, r)
+			s.mu.Lock()
+			return
+		}
+
+		var request struct {
+			Objects []struct {
+				Key string
+			} `xml:"Object"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		xml.Unmarshal(body, &request)
+
+		for _, object := range request.Objects {
+			delete(s.objects, object.Key)
+		}
+
+		fmt.Fprint(w, `<DeleteResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></DeleteResult>`)
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	s.prefixes = append(s.prefixes, prefix)
+
+	var contents strings.Builder
+	for _, key := range s.keys() {
+		if strings.HasPrefix(key, prefix) {
+			fmt.Fprintf(&contents, "<Contents><Key>%s</Key><Size>1</Size></Contents>", key)
+		}
+	}
+
+	fmt.Fprintf(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>previews</Name><Prefix>%s</Prefix><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>%s</ListBucketResult>`, prefix, contents.String())
+}
+
+func (s *fakeObjectStorage) keys() (keys []string) {
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return
+}
+
+func objectStorageTestSetup(t *testing.T, prefix string, keys ...string) (external.ITeardownAction, *fakeObjectStorage) {
+	storage := &fakeObjectStorage{objects: map[string]bool{}}
+	for _, key := range keys {
+		storage.objects[key] = true
+	}
+
+	server := httptest.NewServer(storage)
+	t.Cleanup(server.Close)
+
+	action, err := external.NewTeardownAction(external.TeardownActionConfig{
+		Type:      external.TeardownActionObjectStorage,
+		Address:   strings.TrimPrefix(server.URL, "http://"),
+		Bucket:    "previews",
+		Prefix:    prefix,
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Insecure:  true,
+	})
+	if err != nil {
+		t.Fatalf("NewTeardownAction() should not error, got %s", err)
+	}
+
+	return action, storage
+}
+
+func Test_ObjectStorageTeardownPrefix(t *testing.T) {
+	keys := []string{"feature-1/a.png", "feature-1/b/c.png", "feature-10/a.png", "feature-1.png", "uploads/feature-1/a.png"}
+
+	tests := []struct {
+		name       string
+		prefix     string
+		wantPrefix string
+		wantKept   []string
+	}{
+		{
+			name:       "default prefix ends with a slash",
+			wantPrefix: "feature-1/",
+			wantKept:   []string{"feature-1.png", "feature-10/a.png", "uploads/feature-1/a.png"},
+		},
+		{
+			name:       "configured prefix",
+			prefix:     "uploads/{name}/",
+			wantPrefix: "uploads/feature-1/",
+			wantKept:   []string{"feature-1.png", "feature-1/a.png", "feature-1/b/c.png", "feature-10/a.png"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, storage := objectStorageTestSetup(t, tt.prefix, keys...)
+
+			if err := sut.Teardown(context.Background(), "feature-1"); err != nil {
+				t.Fatalf("Teardown() should not error, got %s", err)
+			}
+
+			if len(storage.prefixes) != 1 || storage.prefixes[0] != tt.wantPrefix {
+				t.Errorf("Teardown() should list objects under %s, got %v", tt.wantPrefix, storage.prefixes)
+			}
+
+			if kept := storage.keys(); strings.Join(kept, " ") != strings.Join(tt.wantKept, " ") {
+				t.Errorf("Teardown() should only remove objects under %s, kept %v", tt.wantPrefix, kept)
+			}
+		})
+	}
+}
+
+func Test_ObjectStorageTeardownRemoveFails(t *testing.T) {
+	sut, storage := objectStorageTestSetup(t, "", "feature-1/a.png")
+	storage.deleteHandler = func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+	}
+
+	if err := sut.Teardown(context.Background(), "feature-1"); err == nil {
+		t.Errorf("Teardown() should error when objects can't be removed")
+	}
+}
+
+func Test_ObjectStorageTeardownCancelled(t *testing.T) {
+	var keys []string
+	for i := 0; i < 1500; i++ {
+		keys = append(keys, fmt.Sprintf("feature-1/%04d.png", i))
+	}
+
+	sut, storage := objectStorageTestSetup(t, "", keys...)
+	release := make(chan struct{})
+	defer close(release)
+	storage.deleteHandler = func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- sut.Teardown(ctx, "feature-1")
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Teardown() should error when cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Teardown() should return once its context is cancelled")
+	}
+}
+
+// fakeRedis answers SCAN and UNLINK over RESP, SCAN returns every matching key in one page.
+type fakeRedis struct {
+	mu       sync.Mutex
+	keys     map[string]bool
+	patterns []string
+}
+
+func redisTestSetup(t *testing.T, prefix string, keys ...string) (external.ITeardownAction, *fakeRedis) {
+	redis := &fakeRedis{keys: map[string]bool{}}
+	for _, key := range keys {
+		redis.keys[key] = true
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go redis.serve(conn)
+		}
+	}()
+
+	action, err := external.NewTeardownAction(external.TeardownActionConfig{
+		Type:    external.TeardownActionRedis,
+		Address: listener.Addr().String(),
+		Prefix:  prefix,
+	})
+	if err != nil {
+		t.Fatalf("NewTeardownAction() should not error, got %s", err)
+	}
+
+	return action, redis
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for {
+		var args []string
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		for i := 0; i < count; i++ {
+			reader.ReadString('\n')
+			arg, _ := reader.ReadString('\n')
+			args = append(args, strings.TrimSuffix(arg, "\r\n"))
+		}
+
+		r.mu.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "SCAN":
+			pattern := args[3]
+			r.patterns = append(r.patterns, pattern)
+
+			var matches []string
+			for key := range r.keys {
+				if ok, _ := path.Match(pattern, key); ok {
+					matches = append(matches, key)
+				}
+			}
+
+			fmt.Fprintf(conn, "*2\r\n$1\r\n0\r\n*%d\r\n", len(matches))
+			for _, key := range matches {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(key), key)
+			}
+		case "UNLINK":
+			for _, key := range args[1:] {
+				delete(r.keys, key)
+			}
+			fmt.Fprintf(conn, ":%d\r\n", len(args)-1)
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *fakeRedis) remaining() (keys []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.keys {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return
+}
+
+func Test_RedisTeardownPrefix(t *testing.T) {
+	tests := []struct {
+		name        string
+		prefix      string
+		keys        []string
+		wantPattern string
+		wantKept    []string
+		wantLog     string
+	}{
+		{
+			name:        "default prefix ends with a colon",
+			keys:        []string{"feature-1:session", "feature-1:cache:a", "feature-10:session", "feature-1", "other:feature-1:a"},
+			wantPattern: "feature-1:*",
+			wantKept:    []string{"feature-1", "feature-10:session", "other:feature-1:a"},
+			wantLog:     "Deleted 2 redis keys with prefix feature-1:",
+		},
+		{
+			name:        "glob characters in the prefix are escaped",
+			prefix:      `cache[v1]*?\{name}:`,
+			keys:        []string{`cache[v1]*?\feature-1:a`, `cachev*?\feature-1:a`, `cache[v1]xy\feature-1:a`},
+			wantPattern: `cache\[v1\]\*\?\\feature-1:*`,
+			wantKept:    []string{`cache[v1]xy\feature-1:a`, `cachev*?\feature-1:a`},
+			wantLog:     `Deleted 1 redis keys with prefix cache[v1]*?\feature-1:`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, redis := redisTestSetup(t, tt.prefix, tt.keys...)
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			if err := sut.Teardown(context.Background(), "feature-1"); err != nil {
+				t.Fatalf("Teardown() should not error, got %s", err)
+			}
+
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("Teardown() should log %q, got %q", tt.wantLog, logs.String())
+			}
+
+			if len(redis.patterns) != 1 || redis.patterns[0] != tt.wantPattern {
+				t.Errorf("Teardown() should scan for %s, got %v", tt.wantPattern, redis.patterns)
+			}
+
+			if kept := redis.remaining(); strings.Join(kept, " ") != strings.Join(tt.wantKept, " ") {
+				t.Errorf("Teardown() should only delete keys starting with the prefix, kept %v", kept)
+			}
+		})
+	}
+}
+
+func dnsTestSetup(t *testing.T, config external.TeardownActionConfig, rcode int) (external.ITeardownAction, chan *dns.Msg) {
+	updates := make(chan *dns.Msg, 1)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The default accept func answers updates with NOTIMP.
+	accept := func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }
+	server := &dns.Server{PacketConn: conn, TsigSecret: map[string]string{"collie.": "c2VjcmV0"}, MsgAcceptFunc: accept, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if req.IsTsig() != nil && w.TsigStatus() != nil {
+			rcode = dns.RcodeNotAuth
+		}
+
+		updates <- req
+		res := new(dns.Msg)
+		res.SetRcode(req, rcode)
+		w.WriteMsg(res)
+	})}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	config.Type = external.TeardownActionDNS
+	config.Address = conn.LocalAddr().String()
+
+	action, err := external.NewTeardownAction(config)
+	if err != nil {
+		t.Fatalf("NewTeardownAction() should not error, got %s", err)
+	}
+
+	return action, updates
+}
+
+func Test_DNSTeardown(t *testing.T) {
+	tests := []struct {
+		name   string
+		config external.TeardownActionConfig
+	}{
+		{name: "without tsig", config: external.TeardownActionConfig{Zone: "preview.example.com", Record: "{name}.preview.example.com"}},
+		{name: "with tsig", config: external.TeardownActionConfig{Zone: "preview.example.com.", Record: "{name}.preview.example.com.", TSIGKey: "collie", TSIGSecret: "c2VjcmV0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, updates := dnsTestSetup(t, tt.config, dns.RcodeSuccess)
+
+			if err := sut.Teardown(context.Background(), "feature-1"); err != nil {
+				t.Fatalf("Teardown() should not error, got %s", err)
+			}
+
+			update := <-updates
+
+			if update.Opcode != dns.OpcodeUpdate || len(update.Question) != 1 || update.Question[0].Name != "preview.example.com." {
+				t.Errorf("Teardown() should send an update for the zone, got %s", update)
+			}
+
+			if len(update.Ns) != 1 {
+				t.Fatalf("Teardown() should remove a single name, got %v", update.Ns)
+			}
+
+			header := update.Ns[0].Header()
+			if header.Name != "feature-1.preview.example.com." || header.Rrtype != dns.TypeANY || header.Class != dns.ClassANY {
+				t.Errorf("Teardown() should remove every record set of feature-1.preview.example.com., got %s", update.Ns[0])
+			}
+		})
+	}
+}
+
+func Test_DNSTeardownErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config external.TeardownActionConfig
+		rcode  int
+	}{
+		{name: "record outside the zone", config: external.TeardownActionConfig{Zone: "preview.example.com", Record: "{name}.example.org"}, rcode: dns.RcodeSuccess},
+		{name: "refused", config: external.TeardownActionConfig{Zone: "preview.example.com", Record: "{name}.preview.example.com"}, rcode: dns.RcodeRefused},
+		{name: "wrong tsig secret", config: external.TeardownActionConfig{Zone: "preview.example.com", Record: "{name}.preview.example.com", TSIGKey: "collie", TSIGSecret: "d3Jvbmc="}, rcode: dns.RcodeSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sut, _ := dnsTestSetup(t, tt.config, tt.rcode)
+
+			if err := sut.Teardown(context.Background(), "feature-1"); err == nil {
+				t.Errorf("Teardown() should error")
+			}
+		})
+	}
+}
+
+// fakeHelm puts a helm script on the PATH that records its arguments and prints output, failing when exitCode is set.
+func fakeHelm(t *testing.T, output string, exitCode int) (argsFile string) {
+	dir := t.TempDir()
+	argsFile = filepath.Join(dir, "args")
+	script := fmt.Sprintf("#!/bin/sh\necho \"$@\" > %s\necho '%s'\nexit %d\n", argsFile, output, exitCode)
+
+	if err := os.WriteFile(filepath.Join(dir, "helm"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+
+	return
+}
+
+func Test_HelmTeardown(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   external.TeardownActionConfig
+		output   string
+		exitCode int
+		wantArgs string
+		wantErr  bool
+	}{
+		{name: "defaults to the name", wantArgs: "uninstall feature-1 --namespace feature-1"},
+		{name: "configured release and namespace", config: external.TeardownActionConfig{Release: "{name}-api", Namespace: "previews"}, wantArgs: "uninstall feature-1-api --namespace previews"},
+		{name: "missing release", output: "Error: uninstall: Release not loaded: feature-1: release: not found", exitCode: 1, wantArgs: "uninstall feature-1 --namespace feature-1"},
+		{name: "failed", output: "Error: Kubernetes cluster unreachable", exitCode: 1, wantArgs: "uninstall feature-1 --namespace feature-1", wantErr: true},
+		{name: "other not found error", output: "Error: uninstall: secrets not found in namespace feature-1", exitCode: 1, wantArgs: "uninstall feature-1 --namespace feature-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			argsFile := fakeHelm(t, tt.output, tt.exitCode)
+
+			tt.config.Type = external.TeardownActionHelm
+			sut, err := external.NewTeardownAction(tt.config)
+			if err != nil {
+				t.Fatalf("NewTeardownAction() should not error, got %s", err)
+			}
+
+			err = sut.Teardown(context.Background(), "feature-1")
+
+			if tt.wantErr != (err != nil) {
+				t.Errorf("Teardown() should error: %t, got %v", tt.wantErr, err)
+			}
+
+			if tt.wantErr && !strings.Contains(err.Error(), tt.output) {
+				t.Errorf("Teardown() should add helm's output to the error, got %s", err)
+			}
+
+			if args, _ := os.ReadFile(argsFile); strings.TrimSpace(string(args)) != tt.wantArgs {
+				t.Errorf("Teardown() should run helm %s, got %s", tt.wantArgs, args)
+			}
+		})
+	}
+}
+
+func Test_DatabaseTeardownActionsConnectionFails(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	tests := []external.TeardownActionConfig{
+		{Type: external.TeardownActionPostgres, ConnectionString: "postgres://collie@" + address + "/postgres"},
+		{Type: external.TeardownActionMySQL, ConnectionString: "collie@tcp(" + address + ")/"},
+	}
+
+	for _, config := range tests {
+		t.Run(string(config.Type), func(t *testing.T) {
+			sut, err := external.NewTeardownAction(config)
+			if err != nil {
+				t.Fatalf("NewTeardownAction() should not error, got %s", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err = sut.Teardown(ctx, "feature-1"); err == nil {
+				t.Errorf("Teardown() should error when the server can't be reached")
+			}
+		})
+	}
+}
+
+func Test_NewTeardownActionRequiredSettings(t *testing.T) {
+	tests := []struct {
+		config  external.TeardownActionConfig
+		wantErr string
+	}{
+		{config: external.TeardownActionConfig{Type: external.TeardownActionPostgres}, wantErr: "missing connectionString"},
+		{config: external.TeardownActionConfig{Type: external.TeardownActionMySQL}, wantErr: "missing connectionString"},
+		{config: external.TeardownActionConfig{Type: external.TeardownActionRedis}, wantErr: "missing address"},
+		{config: external.TeardownActionConfig{Type: external.TeardownActionObjectStorage, Address: "minio:9000"}, wantErr: "missing bucket"},
+		{config: external.TeardownActionConfig{Type: external.TeardownActionDNS, Address: "ns:53"}, wantErr: "missing record, zone"},
+		{config: external.TeardownActionConfig{Type: external.TeardownActionDNS, Address: "ns:53", Zone: "example.com", Record: "{name}.example.com", TSIGKey: "collie"}, wantErr: "tsigKey and tsigSecret must be set together"},
+		{config: external.TeardownActionConfig{Type: "ftp"}, wantErr: "Unknown teardown action type"},
+	}
+
+	for _, tt := range tests {
+		if _, err := external.NewTeardownAction(tt.config); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("NewTeardownAction(%s) should error with %q, got %v", tt.config.Type, tt.wantErr, err)
+		}
+	}
+}
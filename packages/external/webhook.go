@@ -0,0 +1,100 @@
+package external
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidSignature = errors.New("Invalid webhook signature")
+
+type PullRequestEvent struct {
+	Branch string
+	Closed bool
+}
+
+type BitbucketWebhookModel struct {
+	PullRequest PullRequestModel `json:"pullrequest"`
+}
+
+type GHWebhookModel struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Head GHRefModel `json:"head"`
+	} `json:"pull_request"`
+}
+
+// ParseBitbucketWebhook validates the X-Hub-Signature header and returns the pull request event.
+// Non pull request events return a nil event.
+func ParseBitbucketWebhook(header http.Header, body []byte, secret string) (event *PullRequestEvent, err error) {
+	if err = validateSignature(header.Get("X-Hub-Signature"), body, secret); err != nil {
+		return nil, err
+	}
+
+	eventKey := header.Get("X-Event-Key")
+	if !strings.HasPrefix(eventKey, "pullrequest:") {
+		return nil, nil
+	}
+
+	var model BitbucketWebhookModel
+	if err = json.Unmarshal(body, &model); err != nil {
+		return nil, errors.Wrap(err, "Failed to Unmarshal bitbucket webhook")
+	}
+
+	return &PullRequestEvent{
+		Branch: model.PullRequest.Source.Branch.Name,
+		Closed: eventKey == "pullrequest:fulfilled" || eventKey == "pullrequest:rejected",
+	}, nil
+}
+
+// ParseGithubWebhook validates the X-Hub-Signature-256 header and returns the pull request event.
+// Non pull request events return a nil event.
+func ParseGithubWebhook(header http.Header, body []byte, secret string) (event *PullRequestEvent, err error) {
+	if err = validateSignature(header.Get("X-Hub-Signature-256"), body, secret); err != nil {
+		return nil, err
+	}
+
+	if header.Get("X-GitHub-Event") != "pull_request" {
+		return nil, nil
+	}
+
+	var model GHWebhookModel
+	if err = json.Unmarshal(body, &model); err != nil {
+		return nil, errors.Wrap(err, "Failed to Unmarshal github webhook")
+	}
+
+	return &PullRequestEvent{
+		Branch: model.PullRequest.Head.Ref,
+		Closed: model.Action == "closed",
+	}, nil
+}
+
+func validateSignature(signature string, body []byte, secret string) error {
+	if secret == "" {
+		return errors.Wrap(ErrInvalidSignature, "No webhook secret configured")
+	}
+
+	sig := strings.TrimPrefix(signature, "sha256=")
+	if sig == "" || sig == signature {
+		return errors.Wrap(ErrInvalidSignature, "Missing sha256 signature")
+	}
+
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, "Signature is not valid hex")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
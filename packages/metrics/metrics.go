@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const namespace = "collie"
+
+var (
+	Registry = prometheus.NewRegistry()
+
+	NamespacesScanned = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "namespaces_scanned_total",
+		Help:      "Number of namespaces compared against open pull requests.",
+	})
+
+	NamespacesDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "namespaces_deleted_total",
+		Help:      "Number of namespaces deleted.",
+	})
+
+	CleanupJobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cleanup_job_duration_seconds",
+		Help:      "Time from creating a cleanup job until it finished.",
+		Buckets:   []float64{5, 10, 20, 30, 60, 120, 300, 600},
+	}, []string{"result"})
+
+	CleanupJobFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cleanup_job_failures_total",
+		Help:      "Number of cleanup jobs that failed or timed out.",
+	})
+
+	GitProviderRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "git_provider_request_duration_seconds",
+		Help:      "Latency of git provider api calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "operation"})
+
+	GitProviderRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "git_provider_request_errors_total",
+		Help:      "Number of failed git provider api calls.",
+	}, []string{"provider", "operation"})
+
+	DatabasesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "databases_dropped_total",
+		Help:      "Number of databases dropped.",
+	})
+
+	DatabasesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "databases_created_total",
+		Help:      "Number of databases created.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		NamespacesScanned,
+		NamespacesDeleted,
+		CleanupJobDuration,
+		CleanupJobFailures,
+		GitProviderRequestDuration,
+		GitProviderRequestErrors,
+		DatabasesDropped,
+		DatabasesCreated,
+	)
+}
+
+// Handler serves the collie metrics for scraping.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Push sends the collie metrics to a Prometheus pushgateway, used by one-shot runs that exit before being scraped.
+func Push(url string, job string) (err error) {
+	if err = push.New(url, job).Gatherer(Registry).Push(); err != nil {
+		return errors.Wrapf(err, "Failed to push metrics to %s", url)
+	}
+
+	return
+}
@@ -0,0 +1,48 @@
+package metrics_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bitbucket.org/centeva/collie/packages/metrics"
+)
+
+func Test_Push(t *testing.T) {
+	var method, path, body string
+	pushGateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, path, body = r.Method, r.URL.Path, string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer pushGateway.Close()
+
+	metrics.NamespacesDeleted.Inc()
+
+	if err := metrics.Push(pushGateway.URL, "collie-cleanup"); err != nil {
+		t.Fatalf("Push() should not error, got %s", err)
+	}
+
+	if method != http.MethodPut || path != "/metrics/job/collie-cleanup" {
+		t.Errorf("Push() should PUT to /metrics/job/collie-cleanup, got %s %s", method, path)
+	}
+
+	if !strings.Contains(body, "collie_namespaces_deleted_total") {
+		t.Errorf("Push() should send the collie metrics, got %q", body)
+	}
+}
+
+func Test_PushFails(t *testing.T) {
+	pushGateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer pushGateway.Close()
+
+	err := metrics.Push(pushGateway.URL, "collie-cleanup")
+
+	if err == nil || !strings.Contains(err.Error(), "Failed to push metrics to "+pushGateway.URL) {
+		t.Errorf("Push() should error when the pushgateway rejects the metrics, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package testutils
+
+import (
+	"context"
+	"io"
+
+	"bitbucket.org/centeva/collie/packages/external"
+)
+
+type MockDatabaseManager struct {
+	Called     map[string]int
+	CalledWith map[string][]interface{}
+
+	ListDatabasesRes   []string
+	DeleteDatabaseErrs map[string]error
+	CreateDatabaseRes  bool
+	CreateDatabaseErr  error
+	CreateRoleRes      bool
+	BackupDatabaseErr  error
+	DropRoleErr        error
+	// DeleteDatabaseMissing makes DeleteDatabase report these databases as not dropped.
+	DeleteDatabaseMissing map[string]bool
+	// DeleteDatabaseTimesOut makes DeleteDatabase wait for ctx to end for these databases.
+	DeleteDatabaseTimesOut map[string]bool
+	// ListDatabasesHook runs when ListDatabases is called.
+	ListDatabasesHook func()
+}
+
+func NewMockDatabaseManager() *MockDatabaseManager {
+	return &MockDatabaseManager{
+		Called:     make(map[string]int),
+		CalledWith: make(map[string][]interface{}),
+	}
+}
+
+type DMConnectArgs struct {
+	ConnectionString string
+	HasDeadline      bool
+}
+
+func (m *MockDatabaseManager) Connect(ctx context.Context, connectionString string) (err error) {
+	m.Called["connect"]++
+	m.CalledWith["connect"] = append(m.CalledWith["connect"], &DMConnectArgs{connectionString, hasDeadline(ctx)})
+	return
+}
+
+type DMTerminateConnectionsArgs struct {
+	Database string
+}
+
+func (m *MockDatabaseManager) TerminateConnections(ctx context.Context, database string) (err error) {
+	m.Called["terminateconnections"]++
+	m.CalledWith["terminateconnections"] = append(m.CalledWith["terminateconnections"], &DMTerminateConnectionsArgs{database})
+	return
+}
+
+type DMDeleteDatabaseArgs struct {
+	Database    string
+	HasDeadline bool
+}
+
+func (m *MockDatabaseManager) DeleteDatabase(ctx context.Context, database string) (dropped bool, err error) {
+	m.Called["deletedatabase"]++
+	m.CalledWith["deletedatabase"] = append(m.CalledWith["deletedatabase"], &DMDeleteDatabaseArgs{database, hasDeadline(ctx)})
+
+	if m.DeleteDatabaseTimesOut[database] {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+
+	if err = m.DeleteDatabaseErrs[database]; err != nil {
+		return false, err
+	}
+
+	return !m.DeleteDatabaseMissing[database], nil
+}
+
+func (m *MockDatabaseManager) ListDatabases(ctx context.Context) (databases []string, err error) {
+	m.Called["listdatabases"]++
+
+	if m.ListDatabasesHook != nil {
+		m.ListDatabasesHook()
+	}
+
+	return m.ListDatabasesRes, nil
+}
+
+type DMCreateDatabaseArgs struct {
+	Database string
+	Options  external.CreateDatabaseOptions
+}
+
+func (m *MockDatabaseManager) CreateDatabase(ctx context.Context, database string, options external.CreateDatabaseOptions) (created bool, err error) {
+	m.Called["createdatabase"]++
+	m.CalledWith["createdatabase"] = append(m.CalledWith["createdatabase"], &DMCreateDatabaseArgs{database, options})
+	return m.CreateDatabaseRes, m.CreateDatabaseErr
+}
+
+type DMCreateRoleArgs struct {
+	Role     string
+	Password string
+}
+
+func (m *MockDatabaseManager) CreateRole(ctx context.Context, role string, password string) (created bool, err error) {
+	m.Called["createrole"]++
+	m.CalledWith["createrole"] = append(m.CalledWith["createrole"], &DMCreateRoleArgs{role, password})
+	return m.CreateRoleRes, nil
+}
+
+type DMDropRoleArgs struct {
+	Role string
+}
+
+func (m *MockDatabaseManager) DropRole(ctx context.Context, role string) (err error) {
+	m.Called["droprole"]++
+	m.CalledWith["droprole"] = append(m.CalledWith["droprole"], &DMDropRoleArgs{role})
+	return m.DropRoleErr
+}
+
+type DMBackupDatabaseArgs struct {
+	Database string
+}
+
+// BackupDatabase writes "dump of <database>" as the backup.
+func (m *MockDatabaseManager) BackupDatabase(ctx context.Context, database string, w io.Writer) (err error) {
+	m.Called["backupdatabase"]++
+	m.CalledWith["backupdatabase"] = append(m.CalledWith["backupdatabase"], &DMBackupDatabaseArgs{database})
+
+	if m.BackupDatabaseErr != nil {
+		return m.BackupDatabaseErr
+	}
+
+	_, err = io.WriteString(w, "dump of "+database)
+	return
+}
+
+func (m *MockDatabaseManager) Close(ctx context.Context) {
+	m.Called["close"]++
+}
+
+func hasDeadline(ctx context.Context) bool {
+	_, ok := ctx.Deadline()
+	return ok
+}
@@ -4,6 +4,7 @@ type MockFileReader struct {
 	Called      map[string]int
 	CalledWith  map[string][]interface{}
 	ReadFileRes []byte
+	Files       map[string][]byte
 }
 
 func NewMockFileReader(ReadFileRes string) *MockFileReader {
@@ -11,6 +12,7 @@ func NewMockFileReader(ReadFileRes string) *MockFileReader {
 		Called:      make(map[string]int),
 		CalledWith:  make(map[string][]interface{}),
 		ReadFileRes: []byte(ReadFileRes),
+		Files:       make(map[string][]byte),
 	}
 }
 
@@ -22,5 +24,9 @@ func (m *MockFileReader) ReadFile(filename string) ([]byte, error) {
 	m.Called["readfile"]++
 	m.CalledWith["readfile"] = append(m.CalledWith["readfile"], &FRReadFileArgs{filename})
 
+	if file, ok := m.Files[filename]; ok {
+		return file, nil
+	}
+
 	return m.ReadFileRes, nil
 }
@@ -1,6 +1,11 @@
 package testutils
 
-import "bitbucket.org/centeva/collie/packages/external"
+import (
+	"flag"
+	"strings"
+
+	"bitbucket.org/centeva/collie/packages/external"
+)
 
 type MockFlagProvider struct {
 	Called     map[string]int
@@ -29,6 +34,12 @@ type StringVarArgs struct {
 	usage string
 }
 
+type BoolArgs struct {
+	name  string
+	value bool
+	usage string
+}
+
 type StringArgs struct {
 	name  string
 	value string
@@ -82,6 +93,8 @@ type mockFlagSet struct {
 	calledWith map[string][]interface{}
 	argRes     string
 	stringRes  string
+	boolRes    bool
+	args       []string
 }
 
 func NewMockFlagSet(argRes string) *mockFlagSet {
@@ -107,6 +120,17 @@ func (m *mockFlagSet) String(name string, value string, usage string) *string {
 	return &m.stringRes
 }
 
+func (m *mockFlagSet) Bool(name string, value bool, usage string) *bool {
+	m.called["bool"]++
+	m.calledWith["bool"] = append(m.calledWith["bool"], &BoolArgs{
+		name,
+		value,
+		usage,
+	})
+
+	return &m.boolRes
+}
+
 func (m *mockFlagSet) StringVar(p *string, name string, value string, usage string) {
 	m.called["stringvar"]++
 
@@ -118,11 +142,29 @@ func (m *mockFlagSet) StringVar(p *string, name string, value string, usage stri
 	})
 }
 
+// Parse takes every argument that doesn't start with - as positional, so tests pass flags as --Flag=value.
 func (m *mockFlagSet) Parse(arguments []string) error {
 	m.called["parse"]++
+	m.args = nil
+
+	for _, argument := range arguments {
+		if argument == "-h" || argument == "--help" {
+			return flag.ErrHelp
+		}
+
+		if !strings.HasPrefix(argument, "-") {
+			m.args = append(m.args, argument)
+		}
+	}
+
 	return nil
 }
 
+func (m *mockFlagSet) Args() []string {
+	m.called["args"]++
+	return m.args
+}
+
 func (m *mockFlagSet) Arg(i int) string {
 	m.called["arg"]++
 
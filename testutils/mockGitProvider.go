@@ -7,6 +7,7 @@ type MockGitProvider struct {
 	CalledWith     map[string][]interface{}
 	AuthRes        *external.AuthModel
 	GetBranchesRes []string
+	GetBranchesErr error
 }
 
 func NewMockGitProvider() *MockGitProvider {
@@ -50,7 +51,7 @@ func (m *MockGitProvider) GetOpenPRBranches(workspace string, repo string) (bran
 		repo,
 	})
 
-	return m.GetBranchesRes, nil
+	return m.GetBranchesRes, m.GetBranchesErr
 }
 
 type GPAuthArgs struct {
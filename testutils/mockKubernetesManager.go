@@ -2,71 +2,176 @@ package testutils
 
 import (
 	"context"
+	"time"
 
 	"bitbucket.org/centeva/collie/packages/external"
-	"k8s.io/client-go/kubernetes"
 )
 
 type MockKubernetesManager struct {
-	Called           map[string]int
-	CalledWith       map[string][]interface{}
-	GetNamespacesRes []string
+	Called              map[string]int
+	CalledWith          map[string][]interface{}
+	GetNamespacesRes    []string
+	CreateCleanupJobRes external.CleanupJobAction
+	// CreateCleanupJobBlocks makes CreateCleanupJob wait until its context is done and return its error.
+	CreateCleanupJobBlocks bool
+	ScaleDownWorkloadsErr  error
+	// ScaleDownWorkloadsBlocks makes ScaleDownWorkloads wait until its context is done and return its error.
+	ScaleDownWorkloadsBlocks   bool
+	WaitForNamespaceDeletedRes []error
+	GetNamespaceStatusRes      *external.NamespaceStatus
+	NamespaceRes               string
+	// LoseLease cancels the context passed to onStartedLeading before it runs.
+	LoseLease bool
+	// LoseLeaseAfter cancels the context passed to onStartedLeading after it has run for the duration.
+	LoseLeaseAfter time.Duration
 }
 
 func NewMockKubernetesManager() *MockKubernetesManager {
 	return &MockKubernetesManager{
-		Called:     make(map[string]int),
-		CalledWith: make(map[string][]interface{}),
+		Called:              make(map[string]int),
+		CalledWith:          make(map[string][]interface{}),
+		CreateCleanupJobRes: external.CleanupJobCreated,
 	}
 }
 
-type KMInClusterConfigArgs struct {
+type KMConnectArgs struct {
 	Context context.Context
+	Cluster external.ClusterConfig
 }
 
-func (m *MockKubernetesManager) InClusterConfig(context context.Context) (client *kubernetes.Clientset, err error) {
-	m.Called["inclusterconfig"]++
-	m.CalledWith["inclusterconfig"] = append(m.CalledWith["inclusterconfig"], &KMInClusterConfigArgs{context})
+func (m *MockKubernetesManager) Connect(context context.Context, cluster external.ClusterConfig) (err error) {
+	m.Called["connect"]++
+	m.CalledWith["connect"] = append(m.CalledWith["connect"], &KMConnectArgs{context, cluster})
 	return
 }
 
-type KMOutClusterConfigArgs struct {
-	Context    context.Context
-	Kubeconfig string
-}
-
-func (m *MockKubernetesManager) OutClusterConfig(context context.Context, kubeconfig string) (client *kubernetes.Clientset, err error) {
-	m.Called["outclusterconfig"]++
-	m.CalledWith["outclusterconfig"] = append(m.CalledWith["outclusterconfig"], &KMOutClusterConfigArgs{context, kubeconfig})
-	return
+func (m *MockKubernetesManager) Namespace() string {
+	m.Called["namespace"]++
+	return m.NamespaceRes
 }
 
 type KMDeleteNamespaceArgs struct {
 	Namespace string
 }
 
-func (m *MockKubernetesManager) DeleteNamespace(namespace string) (err error) {
+func (m *MockKubernetesManager) DeleteNamespace(ctx context.Context, namespace string) (err error) {
 	m.Called["deletenamespace"]++
 	m.CalledWith["deletenamespace"] = append(m.CalledWith["deletenamespace"], &KMDeleteNamespaceArgs{namespace})
 	return
 }
 
+type KMWaitForNamespaceDeletedArgs struct {
+	Namespace string
+	Timeout   time.Duration
+}
+
+func (m *MockKubernetesManager) WaitForNamespaceDeleted(ctx context.Context, namespace string, timeout time.Duration) (err error) {
+	m.Called["waitfornamespacedeleted"]++
+	m.CalledWith["waitfornamespacedeleted"] = append(m.CalledWith["waitfornamespacedeleted"], &KMWaitForNamespaceDeletedArgs{namespace, timeout})
+	if call := m.Called["waitfornamespacedeleted"] - 1; call < len(m.WaitForNamespaceDeletedRes) {
+		return m.WaitForNamespaceDeletedRes[call]
+	}
+	return
+}
+
+type KMGetNamespaceStatusArgs struct {
+	Namespace string
+}
+
+func (m *MockKubernetesManager) GetNamespaceStatus(ctx context.Context, namespace string) (status *external.NamespaceStatus, err error) {
+	m.Called["getnamespacestatus"]++
+	m.CalledWith["getnamespacestatus"] = append(m.CalledWith["getnamespacestatus"], &KMGetNamespaceStatusArgs{namespace})
+	return m.GetNamespaceStatusRes, nil
+}
+
+type KMRemoveFinalizersArgs struct {
+	Resources []external.NamespaceResource
+}
+
+func (m *MockKubernetesManager) RemoveFinalizers(ctx context.Context, resources []external.NamespaceResource) (err error) {
+	m.Called["removefinalizers"]++
+	m.CalledWith["removefinalizers"] = append(m.CalledWith["removefinalizers"], &KMRemoveFinalizersArgs{resources})
+	return
+}
+
+type KMScaleDownWorkloadsArgs struct {
+	Namespace   string
+	HasDeadline bool
+}
+
+func (m *MockKubernetesManager) ScaleDownWorkloads(ctx context.Context, namespace string) (err error) {
+	m.Called["scaledownworkloads"]++
+	m.CalledWith["scaledownworkloads"] = append(m.CalledWith["scaledownworkloads"], &KMScaleDownWorkloadsArgs{namespace, hasDeadline(ctx)})
+
+	if m.ScaleDownWorkloadsBlocks {
+		<-ctx.Done()
+		m.Called["scaledownworkloadsreturned"]++
+		return ctx.Err()
+	}
+
+	return m.ScaleDownWorkloadsErr
+}
+
 type KMGetNamespacesArgs struct {
 	Label string
 }
 
-func (m *MockKubernetesManager) GetNamespaces(label string) (namespaces []string, err error) {
+func (m *MockKubernetesManager) GetNamespaces(ctx context.Context, label string) (namespaces []string, err error) {
 	m.Called["getnamespaces"]++
 	m.CalledWith["getnamespaces"] = append(m.CalledWith["getnamespaces"], &KMGetNamespacesArgs{label})
 	return m.GetNamespacesRes, nil
 }
 
+type KMApplySecretArgs struct {
+	Namespace string
+	Name      string
+	Data      map[string]string
+}
+
+func (m *MockKubernetesManager) ApplySecret(namespace string, name string, data map[string]string) (err error) {
+	m.Called["applysecret"]++
+	m.CalledWith["applysecret"] = append(m.CalledWith["applysecret"], &KMApplySecretArgs{namespace, name, data})
+	return
+}
+
 type KMCreateCleanupJobArgs struct {
 	Config *external.CleanupJobConfig
 }
 
-func (m *MockKubernetesManager) CreateCleanupJob(config *external.CleanupJobConfig) (err error) {
+func (m *MockKubernetesManager) CreateCleanupJob(ctx context.Context, config *external.CleanupJobConfig) (action external.CleanupJobAction, err error) {
 	m.Called["createcleanupjob"]++
 	m.CalledWith["createcleanupjob"] = append(m.CalledWith["createcleanupjob"], &KMCreateCleanupJobArgs{config})
+
+	if m.CreateCleanupJobBlocks {
+		<-ctx.Done()
+		m.Called["createcleanupjobreturned"]++
+		return "", ctx.Err()
+	}
+
+	return m.CreateCleanupJobRes, nil
+}
+
+type KMRunWithLeaderElectionArgs struct {
+	Context context.Context
+	Config  *external.LeaderElectionConfig
+}
+
+func (m *MockKubernetesManager) RunWithLeaderElection(ctx context.Context, config *external.LeaderElectionConfig, onStartedLeading func(ctx context.Context)) (err error) {
+	m.Called["runwithleaderelection"]++
+	m.CalledWith["runwithleaderelection"] = append(m.CalledWith["runwithleaderelection"], &KMRunWithLeaderElectionArgs{ctx, config})
+
+	if m.LoseLease {
+		leaderCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		ctx = leaderCtx
+	}
+
+	if m.LoseLeaseAfter > 0 {
+		leaderCtx, cancel := context.WithTimeout(ctx, m.LoseLeaseAfter)
+		defer cancel()
+		ctx = leaderCtx
+	}
+
+	onStartedLeading(ctx)
 	return
 }